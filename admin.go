@@ -0,0 +1,184 @@
+package microcache
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AdminHandler returns an http.Handler exposing JSON endpoints for inspecting
+// and managing the cache. It should only be mounted on an internal port.
+//
+//	GET  /stats                               current stats and driver size
+//	GET  /entry?url=/a&header=Accept:text/html cache entry for a URL and request headers
+//	GET  /entries?offset=0&limit=100           request entries (requires IterableDriver)
+//	POST /purge?url=/a                         purge a URL
+//	POST /purge?prefix=/a/                     purge a URL prefix (requires IterableDriver)
+//	POST /purge?tag=product-42                 purge a tag
+//	POST /purge?all=1                          purge everything
+//	POST /purge?url=/a&soft=1                  mark responses stale instead
+//
+// Mount under a path prefix with http.StripPrefix.
+func (m *microcache) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", m.adminStats)
+	mux.HandleFunc("/entry", m.adminEntry)
+	mux.HandleFunc("/entries", m.adminEntries)
+	mux.HandleFunc("/purge", m.adminPurge)
+	return mux
+}
+
+// entryInfo is the JSON representation of an Entry
+type entryInfo struct {
+	Found                bool      `json:"found"`
+	Fresh                bool      `json:"fresh"`
+	Stale                bool      `json:"stale"`
+	Expires              time.Time `json:"expires"`
+	Age                  float64   `json:"age"`
+	Status               int       `json:"status"`
+	Size                 int       `json:"size"`
+	Vary                 []string  `json:"vary"`
+	VaryQuery            []string  `json:"varyQuery"`
+	TTL                  float64   `json:"ttl"`
+	StaleIfError         float64   `json:"staleIfError"`
+	StaleWhileRevalidate float64   `json:"staleWhileRevalidate"`
+	Nocache              bool      `json:"nocache"`
+}
+
+func newEntryInfo(e Entry) entryInfo {
+	return entryInfo{
+		Found:                e.Found,
+		Fresh:                e.Fresh,
+		Stale:                e.Stale,
+		Expires:              e.Expires,
+		Age:                  e.Age.Truncate(time.Second).Seconds(),
+		Status:               e.Status,
+		Size:                 e.Size,
+		Vary:                 e.Vary,
+		VaryQuery:            e.VaryQuery,
+		TTL:                  e.TTL.Seconds(),
+		StaleIfError:         e.StaleIfError.Seconds(),
+		StaleWhileRevalidate: e.StaleWhileRevalidate.Seconds(),
+		Nocache:              e.Nocache,
+	}
+}
+
+func (m *microcache) adminStats(w http.ResponseWriter, r *http.Request) {
+	stats := Stats{}
+	if p, ok := m.Monitor.(interface{ peek() Stats }); ok {
+		stats = p.peek()
+	}
+	stats.Size = m.getSize()
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (m *microcache) adminEntry(w http.ResponseWriter, r *http.Request) {
+	u, err := url.Parse(r.FormValue("url"))
+	if err != nil || r.FormValue("url") == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid url"})
+		return
+	}
+	lr := &http.Request{Method: "GET", URL: u, Header: http.Header{}}
+	for _, hdr := range r.Form["header"] {
+		i := strings.Index(hdr, ":")
+		if i < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid header " + hdr})
+			return
+		}
+		lr.Header.Add(strings.TrimSpace(hdr[:i]), strings.TrimSpace(hdr[i+1:]))
+	}
+	writeJSON(w, http.StatusOK, newEntryInfo(m.Lookup(lr)))
+}
+
+func (m *microcache) adminEntries(w http.ResponseWriter, r *http.Request) {
+	d, ok := iterableDriver(m.Driver)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": ErrNotIterable.Error()})
+		return
+	}
+	offset, _ := strconv.Atoi(r.FormValue("offset"))
+	limit, err := strconv.Atoi(r.FormValue("limit"))
+	if err != nil || limit <= 0 {
+		limit = 100
+	}
+	type entry struct {
+		Hash                 string   `json:"hash"`
+		Path                 string   `json:"path"`
+		Vary                 []string `json:"vary"`
+		VaryQuery            []string `json:"varyQuery"`
+		TTL                  float64  `json:"ttl"`
+		StaleIfError         float64  `json:"staleIfError"`
+		StaleWhileRevalidate float64  `json:"staleWhileRevalidate"`
+		Nocache              bool     `json:"nocache"`
+	}
+	entries := []entry{}
+	var i int
+	d.RangeRequestOpts(func(hash string, req RequestOpts) bool {
+		if i++; i <= offset {
+			return true
+		}
+		entries = append(entries, entry{
+			Hash:                 hex.EncodeToString([]byte(hash)),
+			Path:                 req.path,
+			Vary:                 req.vary,
+			VaryQuery:            req.varyQuery,
+			TTL:                  req.ttl.Seconds(),
+			StaleIfError:         req.staleIfError.Seconds(),
+			StaleWhileRevalidate: req.staleWhileRevalidate.Seconds(),
+			Nocache:              req.nocache,
+		})
+		return len(entries) < limit
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"offset":  offset,
+		"limit":   limit,
+		"entries": entries,
+	})
+}
+
+func (m *microcache) adminPurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	var n int
+	var err error
+	soft := r.FormValue("soft") != ""
+	switch {
+	case r.FormValue("url") != "" && soft:
+		n, err = m.SoftPurge(r.FormValue("url"))
+	case r.FormValue("url") != "":
+		n, err = m.Purge(r.FormValue("url"))
+	case r.FormValue("prefix") != "" && soft:
+		n, err = m.SoftPurgePrefix(r.FormValue("prefix"))
+	case r.FormValue("prefix") != "":
+		n, err = m.PurgePrefix(r.FormValue("prefix"))
+	case r.FormValue("tag") != "" && soft:
+		n = m.SoftPurgeTag(r.FormValue("tag"))
+	case r.FormValue("tag") != "":
+		n = m.PurgeTag(r.FormValue("tag"))
+	case r.FormValue("all") != "" && soft:
+		m.SoftPurgeAll()
+	case r.FormValue("all") != "":
+		m.PurgeAll()
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "url, prefix, tag or all required"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"purged": n})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,96 @@
+package microcache
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// AdminHandler should expose stats, entries and purging
+func TestAdminHandler(t *testing.T) {
+	testMonitor := &monitorFunc{interval: 100 * time.Second, logFunc: func(Stats) {}}
+	cache := New(Config{
+		TTL:          30 * time.Second,
+		StaleIfError: 60 * time.Second,
+		Monitor:      testMonitor,
+		Driver:       NewDriverLRU(10),
+	})
+	defer cache.Stop()
+	handler := cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Vary", "Accept-Language")
+		w.Header().Set("microcache-tags", "t")
+		w.Write([]byte("done"))
+	}))
+	batchGet(handler, []string{"/a", "/a", "/b", "/c"})
+	admin := cache.AdminHandler()
+	var call = func(method, url string, status int, v interface{}) {
+		r, _ := http.NewRequest(method, url, nil)
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, r)
+		if w.Code != status {
+			t.Fatalf("%s %s should return %d, got %d", method, url, status, w.Code)
+		}
+		if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var stats Stats
+	call("GET", "/stats", 200, &stats)
+	if stats.Size != 3 || stats.Hits != 1 || stats.Misses != 3 {
+		t.Fatalf("Stats incorrect %#v", stats)
+	}
+
+	var entry entryInfo
+	var failure map[string]string
+	cache.offsetIncr(40 * time.Second)
+	call("GET", "/entry?url=/a", 200, &entry)
+	if !entry.Found || entry.Fresh || !entry.Stale || entry.Age != 40 || entry.Size != 4 ||
+		entry.Status != 200 || entry.TTL != 30 || entry.StaleIfError != 60 ||
+		strings.Join(entry.Vary, ",") != "Accept-Language" {
+		t.Fatalf("Entry incorrect %#v", entry)
+	}
+	entry = entryInfo{}
+	call("GET", "/entry?url=/a&header=Accept-Language:fr", 200, &entry)
+	if entry.Found || entry.TTL != 30 {
+		t.Fatalf("Entry should not be found for other variant %#v", entry)
+	}
+	call("GET", "/entry?header=a", 400, &failure)
+
+	var list struct {
+		Entries []struct {
+			Path string
+		}
+	}
+	call("GET", "/entries", 200, &list)
+	if len(list.Entries) != 3 {
+		t.Fatalf("Entries incorrect %#v", list)
+	}
+	paths := list.Entries
+	list.Entries = nil
+	call("GET", "/entries?offset=1&limit=1", 200, &list)
+	if len(list.Entries) != 1 || list.Entries[0].Path != paths[1].Path {
+		t.Fatalf("Entries not paged %#v", list)
+	}
+
+	var purged map[string]int
+	call("GET", "/purge?url=/a", 405, &failure)
+	call("POST", "/purge", 400, &failure)
+	call("POST", "/purge?url=/a", 200, &purged)
+	if purged["purged"] != 1 {
+		t.Fatalf("Purge incorrect %#v", purged)
+	}
+	// Response objects of purged requests are removed
+	call("POST", "/purge?tag=t", 200, &purged)
+	if purged["purged"] != 2 {
+		t.Fatalf("PurgeTag incorrect %#v", purged)
+	}
+	call("POST", "/purge?prefix=/", 200, &purged)
+	if purged["purged"] != 3 {
+		t.Fatalf("PurgePrefix incorrect %#v", purged)
+	}
+	call("POST", "/purge?all=1", 200, &purged)
+}
@@ -8,8 +8,13 @@ import (
 // newBackgroundRequest clones a request for use in background object revalidation.
 // This prevents a closed foreground request context from prematurely cancelling
 // the background request context.
+// HEAD requests are revalidated with GET so that the response body can be cached.
 func newBackgroundRequest(r *http.Request) *http.Request {
-	return r.Clone(bgContext{r.Context(), make(chan struct{})})
+	br := r.Clone(bgContext{r.Context(), make(chan struct{})})
+	if br.Method == "HEAD" {
+		br.Method = "GET"
+	}
+	return br
 }
 
 type bgContext struct {
@@ -0,0 +1,160 @@
+package microcache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseCacheControl parses Cache-Control header values into a map of
+// lower case directive names to (unquoted) directive values
+func parseCacheControl(values []string) map[string]string {
+	cc := map[string]string{}
+	for _, hdr := range values {
+		for _, directive := range strings.Split(hdr, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, value := directive, ""
+			if i := strings.Index(directive, "="); i >= 0 {
+				name, value = directive[:i], strings.Trim(strings.TrimSpace(directive[i+1:]), `"`)
+			}
+			cc[strings.ToLower(strings.TrimSpace(name))] = value
+		}
+	}
+	return cc
+}
+
+// ccSeconds returns the value of a delta-seconds directive
+func ccSeconds(cc map[string]string, name string) (time.Duration, bool) {
+	value, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// hasMicrocacheHeader reports whether a response contains any microcache- headers
+// setting cache options. The microcache-tags header only labels responses.
+func hasMicrocacheHeader(headers http.Header) bool {
+	for header := range headers {
+		if strings.HasPrefix(header, "Microcache-") && header != "Microcache-Tags" {
+			return true
+		}
+	}
+	return false
+}
+
+// applyResponseCacheControl derives request options from the standard
+// Cache-Control and Expires response headers (RFC 9111, RFC 5861)
+func applyResponseCacheControl(req *RequestOpts, headers http.Header) {
+	cc := parseCacheControl(headers["Cache-Control"])
+	for _, directive := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := cc[directive]; ok {
+			req.nocache = true
+			return
+		}
+	}
+
+	// s-maxage takes precedence over max-age which takes precedence over Expires
+	ttl, ok := ccSeconds(cc, "s-maxage")
+	if !ok {
+		ttl, ok = ccSeconds(cc, "max-age")
+	}
+	if !ok && headers.Get("Expires") != "" {
+		ok = true
+		if expires, err := http.ParseTime(headers.Get("Expires")); err == nil {
+			date, err := http.ParseTime(headers.Get("Date"))
+			if err != nil {
+				date = time.Now()
+			}
+			ttl = expires.Sub(date)
+		}
+	}
+	if ok {
+		if age, err := strconv.Atoi(headers.Get("Age")); err == nil && age > 0 {
+			ttl -= time.Duration(age) * time.Second
+		}
+		if ttl < time.Second {
+			req.nocache = true
+			return
+		}
+		req.nocache = false
+		req.ttl = ttl.Truncate(time.Second)
+	}
+
+	if staleWhileRevalidate, ok := ccSeconds(cc, "stale-while-revalidate"); ok {
+		req.staleWhileRevalidate = staleWhileRevalidate
+	}
+	if staleIfError, ok := ccSeconds(cc, "stale-if-error"); ok {
+		req.staleIfError = staleIfError
+	}
+}
+
+// requestCacheControl holds the cache directives of a client request (RFC 9111 §5.2.1)
+type requestCacheControl struct {
+	noCache      bool
+	maxAge       time.Duration
+	hasMaxAge    bool
+	maxStale     time.Duration
+	hasMaxStale  bool
+	minFresh     time.Duration
+	onlyIfCached bool
+}
+
+// parseRequestCacheControl parses the Cache-Control and Pragma request headers
+func parseRequestCacheControl(r *http.Request) (rcc requestCacheControl) {
+	values, ok := r.Header["Cache-Control"]
+	if !ok {
+		// HTTP/1.0 clients
+		rcc.noCache = strings.Contains(strings.ToLower(r.Header.Get("Pragma")), "no-cache")
+		return rcc
+	}
+	cc := parseCacheControl(values)
+	_, rcc.noCache = cc["no-cache"]
+	_, rcc.onlyIfCached = cc["only-if-cached"]
+	rcc.maxAge, rcc.hasMaxAge = ccSeconds(cc, "max-age")
+	if rcc.hasMaxAge && rcc.maxAge == 0 {
+		rcc.noCache = true
+	}
+	if maxStale, ok := cc["max-stale"]; ok {
+		rcc.hasMaxStale = true
+		rcc.maxStale = -1
+		if maxStale != "" {
+			rcc.maxStale, rcc.hasMaxStale = ccSeconds(cc, "max-stale")
+		}
+	}
+	rcc.minFresh, _ = ccSeconds(cc, "min-fresh")
+	return rcc
+}
+
+// fresh reports whether the client accepts a cached response as fresh
+func (rcc requestCacheControl) fresh(obj Response, now time.Time) bool {
+	if rcc.noCache {
+		return false
+	}
+	if rcc.hasMaxAge && now.Sub(obj.date) > rcc.maxAge {
+		return false
+	}
+	return obj.expires.After(now.Add(rcc.minFresh))
+}
+
+// allowsStale reports whether the client tolerates stale responses served
+// while revalidating
+func (rcc requestCacheControl) allowsStale() bool {
+	return !rcc.noCache && !rcc.hasMaxAge && rcc.minFresh == 0
+}
+
+// acceptsStale reports whether the client explicitly accepts a stale response (max-stale)
+func (rcc requestCacheControl) acceptsStale(obj Response, now time.Time) bool {
+	if rcc.noCache || !rcc.hasMaxStale {
+		return false
+	}
+	return rcc.maxStale < 0 || obj.expires.Add(rcc.maxStale).After(now)
+}
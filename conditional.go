@@ -0,0 +1,103 @@
+package microcache
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// generateEtag returns a strong validator derived from the response body
+func generateEtag(body []byte) string {
+	sum := sha1.Sum(body)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// notModified reports whether the client already holds the representation
+// described by the validators of a cached response.
+// If-None-Match takes precedence over If-Modified-Since (RFC 9110 §13.2.2)
+func notModified(r *http.Request, header http.Header) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatch(inm, header.Get("Etag"), false)
+	}
+	ims := r.Header.Get("If-Modified-Since")
+	lm := header.Get("Last-Modified")
+	if ims == "" || lm == "" {
+		return false
+	}
+	since, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(lm)
+	if err != nil {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(since)
+}
+
+// etagMatch reports whether etag is contained in a comma separated list of
+// entity tags as found in If-None-Match or If-Match headers.
+// Weak comparison ignores the W/ prefix, strong comparison rejects weak tags.
+func etagMatch(list string, etag string, strong bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	if strong && strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	for {
+		list = strings.TrimLeft(list, " \t,")
+		if list == "" {
+			return false
+		}
+		candidate, rest := scanEtag(list)
+		if candidate == "" {
+			return false
+		}
+		if strong {
+			if !strings.HasPrefix(candidate, "W/") && candidate == etag {
+				return true
+			}
+		} else if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+		list = rest
+	}
+}
+
+// scanEtag returns the first entity tag in s and the remainder of s
+func scanEtag(s string) (etag string, rest string) {
+	start := 0
+	if strings.HasPrefix(s, "W/") {
+		start = 2
+	}
+	if len(s) <= start || s[start] != '"' {
+		return "", ""
+	}
+	end := strings.IndexByte(s[start+1:], '"')
+	if end < 0 {
+		return "", ""
+	}
+	end += start + 2
+	return s[:end], s[end:]
+}
+
+// notModifiedHeaders lists the headers sent along with a 304 response
+// (RFC 9110 §15.4.5)
+var notModifiedHeaders = []string{
+	"Cache-Control",
+	"Content-Location",
+	"Date",
+	"Etag",
+	"Expires",
+	"Last-Modified",
+	"Vary",
+}
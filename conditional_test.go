@@ -0,0 +1,31 @@
+package microcache
+
+import (
+	"testing"
+)
+
+// etagMatch compares entity tags as expected
+func TestEtagMatch(t *testing.T) {
+	cases := []struct {
+		list   string
+		etag   string
+		strong bool
+		match  bool
+	}{
+		{`"a"`, `"a"`, false, true},
+		{`"b"`, `"a"`, false, false},
+		{`"b", "a"`, `"a"`, false, true},
+		{`"b",W/"a"`, `"a"`, false, true},
+		{`W/"a"`, `"a"`, true, false},
+		{`"a"`, `W/"a"`, true, false},
+		{`"a,b"`, `"a,b"`, false, true},
+		{`*`, `"a"`, false, true},
+		{`*`, ``, false, false},
+		{`a`, `"a"`, false, false},
+	}
+	for i, c := range cases {
+		if etagMatch(c.list, c.etag, c.strong) != c.match {
+			t.Fatalf("Match should have been %v for case %d", c.match, i+1)
+		}
+	}
+}
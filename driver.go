@@ -1,5 +1,15 @@
 package microcache
 
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrEntryTooLarge is returned by drivers unable to store an entry because of
+// its size. Requests whose responses are too large are no longer cached.
+var ErrEntryTooLarge = errors.New("microcache: entry exceeds driver size limit")
+
 // Driver is the interface for cache drivers
 type Driver interface {
 
@@ -24,3 +34,145 @@ type Driver interface {
 	// GetSize returns the number of objects stored in the cache
 	GetSize() int
 }
+
+// DriverV2 is the interface for cache drivers which may fail or block, such as
+// network and disk drivers. Each call is bounded by a context and reports
+// errors. The middleware treats failed reads as cache misses, passing requests
+// whose options could not be read through uncached, and reports every failure
+// to monitors implementing DriverErrorMonitor.
+//
+// Drivers implement DriverV2 alongside Driver, which remains required by
+// Config.Driver. Drivers implementing only Driver are called through NewDriverV2.
+type DriverV2 interface {
+	SetRequestOptsContext(ctx context.Context, hash string, req RequestOpts) error
+	GetRequestOptsContext(ctx context.Context, hash string) (req RequestOpts, collision bool, err error)
+	SetContext(ctx context.Context, hash string, res Response) error
+	GetContext(ctx context.Context, hash string) (res Response, collision bool, err error)
+	RemoveContext(ctx context.Context, hash string) error
+	GetSizeContext(ctx context.Context) (int, error)
+}
+
+// NewDriverV2 returns d if it implements DriverV2 and otherwise adapts it.
+// Adapted drivers are not interrupted by the context, which is only checked
+// before each call. Errors returned by Set, SetRequestOpts and Remove are passed
+// through.
+func NewDriverV2(d Driver) DriverV2 {
+	if v2, ok := d.(DriverV2); ok {
+		return v2
+	}
+	return driverAdapter{d}
+}
+
+type driverAdapter struct {
+	d Driver
+}
+
+func (a driverAdapter) SetRequestOptsContext(ctx context.Context, hash string, req RequestOpts) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.d.SetRequestOpts(hash, req)
+}
+
+func (a driverAdapter) GetRequestOptsContext(ctx context.Context, hash string) (RequestOpts, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return RequestOpts{}, false, err
+	}
+	req, collision := a.d.GetRequestOpts(hash)
+	return req, collision, nil
+}
+
+func (a driverAdapter) SetContext(ctx context.Context, hash string, res Response) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.d.Set(hash, res)
+}
+
+func (a driverAdapter) GetContext(ctx context.Context, hash string) (Response, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return Response{}, false, err
+	}
+	res, collision := a.d.Get(hash)
+	return res, collision, nil
+}
+
+func (a driverAdapter) RemoveContext(ctx context.Context, hash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.d.Remove(hash)
+}
+
+func (a driverAdapter) GetSizeContext(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return a.d.GetSize(), nil
+}
+
+// IterableDriver is implemented by drivers able to enumerate their entries.
+// It is required to purge cached responses by URL prefix and to list entries
+// in the admin handler.
+type IterableDriver interface {
+	Driver
+
+	// RangeRequestOpts calls f for each request options entry in the request cache
+	// until f returns false
+	RangeRequestOpts(f func(hash string, req RequestOpts) bool)
+
+	// Range calls f for each response object in the response cache
+	// until f returns false
+	Range(f func(hash string, res Response) bool)
+}
+
+// EvictionNotifier is implemented by drivers able to report response objects
+// leaving the response cache. It is used to keep indexes such as the
+// tag index in sync with the driver.
+type EvictionNotifier interface {
+	// OnEvict registers f to be called with the hash of each response object
+	// evicted or removed from the response cache
+	OnEvict(f func(hash string))
+}
+
+// notifiesEvictions reports whether a driver reports every response object
+// leaving it. DriverTiered only reports the evictions of its L2 driver.
+func notifiesEvictions(d Driver) bool {
+	if t, ok := d.(DriverTiered); ok {
+		return notifiesEvictions(t.L2)
+	}
+	_, ok := d.(EvictionNotifier)
+	return ok
+}
+
+// iterableDriver returns a driver as an IterableDriver if it can enumerate its
+// entries. DriverTiered can only do so when its L2 driver can.
+func iterableDriver(d Driver) (IterableDriver, bool) {
+	if t, ok := d.(DriverTiered); ok {
+		if _, ok := iterableDriver(t.L2); !ok {
+			return nil, false
+		}
+	}
+	i, ok := d.(IterableDriver)
+	return i, ok
+}
+
+// evictListeners holds the functions registered with EvictionNotifier.OnEvict
+type evictListeners struct {
+	mutex sync.RWMutex
+	funcs []func(string)
+}
+
+func (l *evictListeners) add(f func(string)) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.funcs = append(l.funcs, f)
+}
+
+func (l *evictListeners) notify(hash string) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	for _, f := range l.funcs {
+		f(hash)
+	}
+}
@@ -67,3 +67,31 @@ func (c DriverARC) Remove(hash string) error {
 func (c DriverARC) GetSize() int {
 	return c.ResponseCache.Len()
 }
+
+func (c DriverARC) RangeRequestOpts(f func(hash string, req RequestOpts) bool) {
+	for _, key := range c.RequestCache.Keys() {
+		obj, ok := c.RequestCache.Peek(key)
+		if !ok {
+			continue
+		}
+		if !f(key.(string), obj.(RequestOpts)) {
+			return
+		}
+	}
+}
+
+func (c DriverARC) Range(f func(hash string, res Response) bool) {
+	for _, key := range c.ResponseCache.Keys() {
+		obj, ok := c.ResponseCache.Peek(key)
+		if !ok {
+			continue
+		}
+		if !f(key.(string), obj.(Response)) {
+			return
+		}
+	}
+}
+
+func (c DriverARC) containsObject(hash string) bool {
+	return c.ResponseCache.Contains(hash)
+}
@@ -0,0 +1,371 @@
+package microcache
+
+import (
+	"container/list"
+	"context"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DriverDisk is a driver implementation storing entries as files on local disk
+// so that the cache can grow beyond available memory and survive restarts.
+//
+// Entries are stored under a hashed directory layout
+//
+//	dir/3f/a2/3fa2...e1.obj   response object
+//	dir/3f/a2/3fa2...e1.req   request options
+//
+// Files are written to dir/tmp, synced and renamed into place so that a crash
+// never leaves a partially written entry behind. An in-memory LRU index of the
+// files is rebuilt from the directory on startup and the least recently used
+// files are removed once their total size exceeds maxBytes. Response objects
+// are also removed when read after their stale windows have passed.
+//
+// The tag index of the middleware is not persisted, so tags of recovered
+// entries are not known to PurgeTag.
+//
+// DriverDisk implements DriverV2. Errors reading files are returned rather
+// than treated as misses.
+type DriverDisk struct {
+	dir      string
+	maxBytes int64
+	index    *diskIndex
+	evicted  *evictListeners
+}
+
+// NewDriverDisk returns a disk driver storing entries in dir.
+// maxBytes limits the total size of the stored files. 0 means no limit.
+// Entries left in dir by a previous process are recovered.
+func NewDriverDisk(dir string, maxBytes int64) (DriverDisk, error) {
+	d := DriverDisk{
+		dir:      dir,
+		maxBytes: maxBytes,
+		index:    &diskIndex{lru: list.New(), items: map[string]*list.Element{}},
+		evicted:  &evictListeners{},
+	}
+	// Discard incomplete writes
+	if err := os.RemoveAll(filepath.Join(dir, "tmp")); err != nil {
+		return d, err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "tmp"), 0755); err != nil {
+		return d, err
+	}
+	var entries []diskEntry
+	var mtimes = map[string]time.Time{}
+	err := filepath.WalkDir(dir, func(path string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := de.Name()
+		if de.IsDir() || !(strings.HasSuffix(name, ".obj") || strings.HasSuffix(name, ".req")) {
+			return nil
+		}
+		info, err := de.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, diskEntry{name, info.Size()})
+		mtimes[name] = info.ModTime()
+		return nil
+	})
+	if err != nil {
+		return d, err
+	}
+	// Least recently written entries are evicted first
+	sort.Slice(entries, func(i, j int) bool {
+		return mtimes[entries[i].name].Before(mtimes[entries[j].name])
+	})
+	for _, e := range entries {
+		d.removeFiles(d.index.add(e.name, e.size, maxBytes))
+	}
+	return d, nil
+}
+
+func (d DriverDisk) path(name string) string {
+	if len(name) < 8 {
+		return filepath.Join(d.dir, "00", "00", name)
+	}
+	return filepath.Join(d.dir, name[:2], name[2:4], name)
+}
+
+func diskName(hash, ext string) string {
+	return hex.EncodeToString([]byte(hash)) + ext
+}
+
+// write stores a file atomically
+func (d DriverDisk) write(name string, b []byte) error {
+	if d.maxBytes > 0 && int64(len(b)) > d.maxBytes {
+		return ErrEntryTooLarge
+	}
+	f, err := os.CreateTemp(filepath.Join(d.dir, "tmp"), "entry")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	path := d.path(name)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	d.removeFiles(d.index.add(name, int64(len(b)), d.maxBytes))
+	return nil
+}
+
+// read returns the contents of a file and marks it as recently used.
+// Missing files are dropped from the index and reported as not found.
+func (d DriverDisk) read(name string) ([]byte, bool, error) {
+	if !d.index.touch(name) {
+		return nil, false, nil
+	}
+	b, err := os.ReadFile(d.path(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		d.remove(name)
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+func (d DriverDisk) remove(name string) {
+	if d.index.remove(name) {
+		d.removeFiles([]string{name})
+	}
+}
+
+// removeFiles deletes evicted files
+func (d DriverDisk) removeFiles(names []string) {
+	for _, name := range names {
+		os.Remove(d.path(name))
+		if strings.HasSuffix(name, ".obj") {
+			hash, _ := hex.DecodeString(strings.TrimSuffix(name, ".obj"))
+			d.evicted.notify(string(hash))
+		}
+	}
+}
+
+func (d DriverDisk) SetRequestOpts(hash string, req RequestOpts) error {
+	return d.SetRequestOptsContext(context.Background(), hash, req)
+}
+
+func (d DriverDisk) GetRequestOpts(hash string) (RequestOpts, bool) {
+	req, collision, _ := d.GetRequestOptsContext(context.Background(), hash)
+	return req, collision
+}
+
+func (d DriverDisk) Set(hash string, res Response) error {
+	return d.SetContext(context.Background(), hash, res)
+}
+
+func (d DriverDisk) Get(hash string) (Response, bool) {
+	res, collision, _ := d.GetContext(context.Background(), hash)
+	return res, collision
+}
+
+func (d DriverDisk) Remove(hash string) error {
+	return d.RemoveContext(context.Background(), hash)
+}
+
+func (d DriverDisk) GetSize() int {
+	return d.index.count(".obj")
+}
+
+func (d DriverDisk) SetRequestOptsContext(ctx context.Context, hash string, req RequestOpts) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	req.hash = hash
+	b, err := req.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return d.write(diskName(hash, ".req"), b)
+}
+
+func (d DriverDisk) GetRequestOptsContext(ctx context.Context, hash string) (req RequestOpts, collision bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return req, false, err
+	}
+	name := diskName(hash, ".req")
+	b, ok, err := d.read(name)
+	if !ok {
+		return req, false, err
+	}
+	if req.UnmarshalBinary(b) != nil {
+		d.remove(name)
+		return RequestOpts{}, false, nil
+	}
+	return req, req.hash != hash, nil
+}
+
+func (d DriverDisk) SetContext(ctx context.Context, hash string, res Response) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	res.hash = hash
+	b, err := res.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return d.write(diskName(hash, ".obj"), b)
+}
+
+func (d DriverDisk) GetContext(ctx context.Context, hash string) (res Response, collision bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return res, false, err
+	}
+	name := diskName(hash, ".obj")
+	b, ok, err := d.read(name)
+	if !ok {
+		return res, false, err
+	}
+	if res.UnmarshalBinary(b) != nil || time.Now().After(res.expires.Add(res.stale)) {
+		d.remove(name)
+		return Response{}, false, nil
+	}
+	return res, res.hash != hash, nil
+}
+
+func (d DriverDisk) RemoveContext(ctx context.Context, hash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	d.remove(diskName(hash, ".obj"))
+	return nil
+}
+
+func (d DriverDisk) GetSizeContext(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return d.GetSize(), nil
+}
+
+func (d DriverDisk) RangeRequestOpts(f func(hash string, req RequestOpts) bool) {
+	for _, name := range d.index.list(".req") {
+		var req RequestOpts
+		b, err := os.ReadFile(d.path(name))
+		if err != nil || req.UnmarshalBinary(b) != nil {
+			continue
+		}
+		if !f(req.hash, req) {
+			return
+		}
+	}
+}
+
+func (d DriverDisk) Range(f func(hash string, res Response) bool) {
+	for _, name := range d.index.list(".obj") {
+		var res Response
+		b, err := os.ReadFile(d.path(name))
+		if err != nil || res.UnmarshalBinary(b) != nil {
+			continue
+		}
+		if !f(res.hash, res) {
+			return
+		}
+	}
+}
+
+func (d DriverDisk) OnEvict(f func(hash string)) {
+	d.evicted.add(f)
+}
+
+// diskIndex tracks the files stored by DriverDisk in least recently used order
+type diskIndex struct {
+	mutex sync.Mutex
+	lru   *list.List
+	items map[string]*list.Element
+	bytes int64
+}
+
+type diskEntry struct {
+	name string
+	size int64
+}
+
+// add adds or updates a file and returns the names of the files evicted to
+// keep the total size within maxBytes
+func (x *diskIndex) add(name string, size int64, maxBytes int64) (evicted []string) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if el, ok := x.items[name]; ok {
+		x.bytes -= el.Value.(*diskEntry).size
+		el.Value.(*diskEntry).size = size
+		x.lru.MoveToFront(el)
+	} else {
+		x.items[name] = x.lru.PushFront(&diskEntry{name, size})
+	}
+	x.bytes += size
+	for maxBytes > 0 && x.bytes > maxBytes && x.lru.Len() > 1 {
+		e := x.lru.Remove(x.lru.Back()).(*diskEntry)
+		delete(x.items, e.name)
+		x.bytes -= e.size
+		evicted = append(evicted, e.name)
+	}
+	return evicted
+}
+
+func (x *diskIndex) touch(name string) bool {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	el, ok := x.items[name]
+	if ok {
+		x.lru.MoveToFront(el)
+	}
+	return ok
+}
+
+func (x *diskIndex) remove(name string) bool {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	el, ok := x.items[name]
+	if ok {
+		x.lru.Remove(el)
+		delete(x.items, name)
+		x.bytes -= el.Value.(*diskEntry).size
+	}
+	return ok
+}
+
+func (x *diskIndex) list(ext string) (names []string) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	for el := x.lru.Front(); el != nil; el = el.Next() {
+		if name := el.Value.(*diskEntry).name; strings.HasSuffix(name, ext) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (x *diskIndex) count(ext string) (n int) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	for name := range x.items {
+		if strings.HasSuffix(name, ext) {
+			n++
+		}
+	}
+	return n
+}
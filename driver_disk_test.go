@@ -0,0 +1,190 @@
+package microcache
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// DriverDisk should persist entries across restarts
+func TestDriverDisk(t *testing.T) {
+	dir := t.TempDir()
+	var newCache = func() *microcache {
+		d, err := NewDriverDisk(dir, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return New(Config{
+			TTL:     30 * time.Second,
+			Driver:  d,
+			Exposed: true,
+		})
+	}
+	var status = func(cache *microcache, url string) string {
+		handler := cache.Middleware(http.HandlerFunc(noopSuccessHandler))
+		return getResponse(handler, url).Header().Get("microcache")
+	}
+
+	cache := newCache()
+	if status(cache, "/a") != "MISS" || status(cache, "/a") != "HIT" {
+		t.Fatal("DriverDisk should cache responses")
+	}
+	if cache.Driver.GetSize() != 1 {
+		t.Fatal("DriverDisk should report 1 response object")
+	}
+	cache.Stop()
+
+	// Leftover temporary files are discarded on startup
+	tmp := filepath.Join(dir, "tmp", "entry123")
+	if err := os.WriteFile(tmp, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache = newCache()
+	defer cache.Stop()
+	if status(cache, "/a") != "HIT" {
+		t.Fatal("DriverDisk should recover entries on startup")
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Fatal("DriverDisk should remove incomplete writes on startup")
+	}
+
+	// Iterable
+	if n, err := cache.PurgePrefix("/"); n != 1 || err != nil {
+		t.Fatal("DriverDisk should support PurgePrefix", n, err)
+	}
+	if status(cache, "/a") != "MISS" {
+		t.Fatal("DriverDisk should respect purges")
+	}
+
+	// PurgeAll persists across restarts
+	cache.PurgeAll()
+	cache.Stop()
+	cache = newCache()
+	defer cache.Stop()
+	if status(cache, "/a") != "MISS" {
+		t.Fatal("DriverDisk should respect PurgeAll after restart")
+	}
+
+	// Corrupt entries are removed
+	d := cache.Driver.(DriverDisk)
+	r, _ := http.NewRequest("GET", "/a", nil)
+	reqHash := getRequestHash(cache, r)
+	req, _ := d.GetRequestOpts(reqHash)
+	objHash := req.getObjectHash(reqHash, r)
+	path := d.path(diskName(objHash, ".obj"))
+	if err := os.WriteFile(path, []byte("corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if res, _ := d.Get(objHash); res.found {
+		t.Fatal("DriverDisk should not return corrupt entries")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("DriverDisk should remove corrupt entries")
+	}
+
+	// Entries past their stale windows are removed
+	d.Set(objHash, Response{found: true, expires: time.Now().Add(-time.Second), stale: time.Minute})
+	if res, _ := d.Get(objHash); !res.found {
+		t.Fatal("DriverDisk should return stale entries")
+	}
+	d.Set(objHash, Response{found: true, expires: time.Now().Add(-time.Minute), stale: time.Second})
+	if res, _ := d.Get(objHash); res.found || d.GetSize() != 0 {
+		t.Fatal("DriverDisk should remove expired entries")
+	}
+}
+
+// DriverDisk should evict the least recently used entries beyond maxBytes
+func TestDriverDiskEviction(t *testing.T) {
+	d, err := NewDriverDisk(t.TempDir(), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var evicted []string
+	d.OnEvict(func(hash string) {
+		evicted = append(evicted, hash)
+	})
+	res := Response{found: true, expires: time.Now().Add(time.Minute), body: make([]byte, 200)}
+	for i := 0; i < 4; i++ {
+		if err := d.Set(strconv.Itoa(i), res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.Get("0")
+	d.Set("4", res)
+	if d.GetSize() != 4 || d.index.bytes > 1000 || len(evicted) != 1 || evicted[0] != "1" {
+		t.Fatalf("DriverDisk should evict least recently used entry, got %v", evicted)
+	}
+	if res, _ := d.Get("1"); res.found {
+		t.Fatal("Evicted entry should not be found")
+	}
+	if err := d.Set("big", Response{body: make([]byte, 2000)}); err != ErrEntryTooLarge {
+		t.Fatal("DriverDisk should reject entries larger than maxBytes")
+	}
+
+	// Recovery respects maxBytes
+	d, err = NewDriverDisk(d.dir, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.GetSize() != 2 || d.index.bytes > 500 {
+		t.Fatalf("DriverDisk should enforce maxBytes on startup, got %d", d.GetSize())
+	}
+}
+
+// DriverDisk should return canceled contexts and read errors
+func TestDriverDiskContext(t *testing.T) {
+	d, err := NewDriverDisk(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := d.SetContext(ctx, "a", Response{found: true}); err != context.Canceled {
+		t.Fatal("DriverDisk should return canceled contexts")
+	}
+	if _, _, err := d.GetContext(context.Background(), "a"); err != nil {
+		t.Fatal("Missing entries should not be errors")
+	}
+	d.Set("a", Response{found: true, expires: time.Now().Add(time.Minute)})
+	path := d.path(diskName("a", ".obj"))
+	os.Remove(path)
+	os.Mkdir(path, 0755)
+	if res, _, err := d.GetContext(context.Background(), "a"); err == nil || res.found {
+		t.Fatal("DriverDisk should return read errors")
+	}
+}
+
+// DriverDisk should keep expired responses which can be revalidated
+func TestDriverDiskRevalidate(t *testing.T) {
+	d, err := NewDriverDisk(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := New(Config{
+		TTL:        50 * time.Millisecond,
+		Revalidate: true,
+		Driver:     d,
+	})
+	defer cache.Stop()
+	var renders, revalidations int
+	handler := cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Etag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			revalidations++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		renders++
+		w.Write([]byte("done"))
+	}))
+	batchGet(handler, []string{"/"})
+	time.Sleep(60 * time.Millisecond)
+	w := getResponse(handler, "/")
+	if w.Body.String() != "done" || renders != 1 || revalidations != 1 {
+		t.Fatalf("Expired responses should be revalidated, got %d renders %d revalidations", renders, revalidations)
+	}
+}
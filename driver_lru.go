@@ -8,6 +8,8 @@ import (
 type DriverLRU struct {
 	RequestCache  *lru.Cache
 	ResponseCache *lru.Cache
+
+	evicted *evictListeners
 }
 
 // NewDriverLRU returns the default LRU driver configuration.
@@ -20,11 +22,15 @@ func NewDriverLRU(size int) DriverLRU {
 	if size < 1 {
 		size = 1
 	}
+	evicted := &evictListeners{}
 	reqCache, _ := lru.New(size)
-	resCache, _ := lru.New(size)
+	resCache, _ := lru.NewWithEvict(size, func(key, value interface{}) {
+		evicted.notify(key.(string))
+	})
 	return DriverLRU{
 		reqCache,
 		resCache,
+		evicted,
 	}
 }
 
@@ -62,3 +68,33 @@ func (c DriverLRU) Remove(hash string) error {
 func (c DriverLRU) GetSize() int {
 	return c.ResponseCache.Len()
 }
+
+func (c DriverLRU) RangeRequestOpts(f func(hash string, req RequestOpts) bool) {
+	for _, key := range c.RequestCache.Keys() {
+		obj, ok := c.RequestCache.Peek(key)
+		if !ok {
+			continue
+		}
+		if !f(key.(string), obj.(RequestOpts)) {
+			return
+		}
+	}
+}
+
+func (c DriverLRU) Range(f func(hash string, res Response) bool) {
+	for _, key := range c.ResponseCache.Keys() {
+		obj, ok := c.ResponseCache.Peek(key)
+		if !ok {
+			continue
+		}
+		if !f(key.(string), obj.(Response)) {
+			return
+		}
+	}
+}
+
+func (c DriverLRU) OnEvict(f func(hash string)) {
+	if c.evicted != nil {
+		c.evicted.add(f)
+	}
+}
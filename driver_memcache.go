@@ -0,0 +1,343 @@
+package microcache
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DriverMemcache is a driver implementation storing entries in memcached
+// servers using the text protocol. Keys are distributed across servers by
+// consistent hashing so that adding or removing a server only moves a fraction
+// of the keys.
+//
+// Response objects expire once their stale windows have passed. Responses larger
+// than MaxItemSize are not stored and their requests are no longer cached.
+// As a DriverV2, commands are bounded by Config.DriverTimeout.
+type DriverMemcache struct {
+	// Prefix namespaces the keys of this cache
+	Prefix string
+
+	// MaxItemSize is the largest entry stored in bytes
+	MaxItemSize int
+
+	// RequestOptsTTL specifies how long request options are stored.
+	// It should exceed the longest ttl plus stale window of any response.
+	RequestOptsTTL time.Duration
+
+	ring *memcacheRing
+}
+
+// DriverMemcacheConfig configures a DriverMemcache
+type DriverMemcacheConfig struct {
+	// Servers are the addresses of the memcached servers (host:port)
+	Servers []string
+
+	// Prefix namespaces the keys of this cache
+	// Default: "microcache:"
+	Prefix string
+
+	// MaxItemSize is the largest entry stored in bytes. It should not exceed
+	// the item size limit of the servers (-I).
+	// Default: 1MB
+	MaxItemSize int
+
+	// PoolSize is the maximum number of idle connections kept open per server
+	// Default: 10
+	PoolSize int
+
+	// Timeout bounds dialing and each command
+	// Default: 1s
+	Timeout time.Duration
+
+	// RequestOptsTTL specifies how long request options are stored
+	// Default: 24h
+	RequestOptsTTL time.Duration
+}
+
+// NewDriverMemcache returns a memcached driver. Connections are opened on demand.
+func NewDriverMemcache(c DriverMemcacheConfig) DriverMemcache {
+	if c.Prefix == "" {
+		c.Prefix = "microcache:"
+	}
+	if c.MaxItemSize <= 0 {
+		c.MaxItemSize = 1 << 20
+	}
+	if c.PoolSize <= 0 {
+		c.PoolSize = 10
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = time.Second
+	}
+	if c.RequestOptsTTL <= 0 {
+		c.RequestOptsTTL = 24 * time.Hour
+	}
+	ring := &memcacheRing{}
+	for _, addr := range c.Servers {
+		ring.add(newConnPool(addr, c.PoolSize, c.Timeout, nil))
+	}
+	sort.Slice(ring.points, func(i, j int) bool {
+		return ring.points[i].hash < ring.points[j].hash
+	})
+	return DriverMemcache{
+		Prefix:         c.Prefix,
+		MaxItemSize:    c.MaxItemSize,
+		RequestOptsTTL: c.RequestOptsTTL,
+		ring:           ring,
+	}
+}
+
+func (d DriverMemcache) requestKey(hash string) string {
+	return d.Prefix + "req:" + hex.EncodeToString([]byte(hash))
+}
+
+func (d DriverMemcache) responseKey(hash string) string {
+	return d.Prefix + "obj:" + hex.EncodeToString([]byte(hash))
+}
+
+func (d DriverMemcache) SetRequestOpts(hash string, req RequestOpts) error {
+	return d.SetRequestOptsContext(context.Background(), hash, req)
+}
+
+func (d DriverMemcache) GetRequestOpts(hash string) (RequestOpts, bool) {
+	req, collision, _ := d.GetRequestOptsContext(context.Background(), hash)
+	return req, collision
+}
+
+// Set stores a response object until its stale windows have passed
+func (d DriverMemcache) Set(hash string, res Response) error {
+	return d.SetContext(context.Background(), hash, res)
+}
+
+func (d DriverMemcache) Get(hash string) (Response, bool) {
+	res, collision, _ := d.GetContext(context.Background(), hash)
+	return res, collision
+}
+
+func (d DriverMemcache) Remove(hash string) error {
+	return d.RemoveContext(context.Background(), hash)
+}
+
+// GetSize returns the number of items stored by all servers, including items
+// outside of Prefix
+func (d DriverMemcache) GetSize() int {
+	n, _ := d.GetSizeContext(context.Background())
+	return n
+}
+
+func (d DriverMemcache) SetRequestOptsContext(ctx context.Context, hash string, req RequestOpts) error {
+	req.hash = hash
+	b, err := req.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return d.set(ctx, d.requestKey(hash), b, d.RequestOptsTTL)
+}
+
+func (d DriverMemcache) GetRequestOptsContext(ctx context.Context, hash string) (req RequestOpts, collision bool, err error) {
+	b, err := d.get(ctx, d.requestKey(hash))
+	if err == nil && b != nil {
+		if err = req.UnmarshalBinary(b); err != nil {
+			return RequestOpts{}, false, err
+		}
+	}
+	return req, false, err
+}
+
+func (d DriverMemcache) SetContext(ctx context.Context, hash string, res Response) error {
+	ttl := time.Until(res.expires) + res.stale
+	if ttl < time.Second {
+		return d.RemoveContext(ctx, hash)
+	}
+	res.hash = hash
+	b, err := res.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return d.set(ctx, d.responseKey(hash), b, ttl)
+}
+
+func (d DriverMemcache) GetContext(ctx context.Context, hash string) (res Response, collision bool, err error) {
+	b, err := d.get(ctx, d.responseKey(hash))
+	if err == nil && b != nil {
+		if err = res.UnmarshalBinary(b); err != nil {
+			return Response{}, false, err
+		}
+	}
+	return res, false, err
+}
+
+func (d DriverMemcache) RemoveContext(ctx context.Context, hash string) error {
+	key := d.responseKey(hash)
+	return d.ring.pool(key).do(ctx, func(c *poolConn) error {
+		fmt.Fprintf(c.w, "delete %s\r\n", key)
+		line, err := memcacheCommand(c)
+		if err == nil && line != "DELETED" && line != "NOT_FOUND" {
+			err = errMemcacheProtocol
+		}
+		return err
+	})
+}
+
+// GetSizeContext returns the number of items stored by all servers. Servers
+// which fail to respond are skipped and the first error is returned.
+func (d DriverMemcache) GetSizeContext(ctx context.Context) (int, error) {
+	var n int
+	var firstErr error
+	for _, p := range d.ring.pools {
+		err := p.do(ctx, func(c *poolConn) error {
+			c.w.WriteString("stats\r\n")
+			line, err := memcacheCommand(c)
+			for ; err == nil && line != "END"; line, err = readMemcacheLine(c.r) {
+				if strings.HasPrefix(line, "STAT curr_items ") {
+					i, _ := strconv.Atoi(strings.TrimPrefix(line, "STAT curr_items "))
+					n += i
+				}
+			}
+			return err
+		})
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return n, firstErr
+}
+
+func (d DriverMemcache) set(ctx context.Context, key string, b []byte, ttl time.Duration) error {
+	if len(b) > d.MaxItemSize {
+		return ErrEntryTooLarge
+	}
+	return d.ring.pool(key).do(ctx, func(c *poolConn) error {
+		fmt.Fprintf(c.w, "set %s 0 %d %d\r\n", key, memcacheExptime(ttl), len(b))
+		c.w.Write(b)
+		c.w.WriteString("\r\n")
+		line, err := memcacheCommand(c)
+		if e, ok := err.(memcacheError); ok && strings.Contains(string(e), "too large") {
+			return ErrEntryTooLarge
+		}
+		if err == nil && line != "STORED" {
+			err = errMemcacheProtocol
+		}
+		return err
+	})
+}
+
+// get returns the value of a key or nil if it is not found
+func (d DriverMemcache) get(ctx context.Context, key string) (b []byte, err error) {
+	err = d.ring.pool(key).do(ctx, func(c *poolConn) error {
+		fmt.Fprintf(c.w, "get %s\r\n", key)
+		line, err := memcacheCommand(c)
+		if err != nil || line == "END" {
+			return err
+		}
+		// VALUE <key> <flags> <bytes>
+		fields := strings.Fields(line)
+		if len(fields) != 4 || fields[0] != "VALUE" {
+			return errMemcacheProtocol
+		}
+		n, err := strconv.Atoi(fields[3])
+		if err != nil || n < 0 {
+			return errMemcacheProtocol
+		}
+		b = make([]byte, n+2)
+		if _, err = io.ReadFull(c.r, b); err != nil {
+			return err
+		}
+		b = b[:n]
+		if line, err = readMemcacheLine(c.r); err == nil && line != "END" {
+			err = errMemcacheProtocol
+		}
+		return err
+	})
+	return b, err
+}
+
+// memcacheExptime converts a ttl to an expiration time. Times beyond 30 days
+// must be sent as unix timestamps.
+func memcacheExptime(ttl time.Duration) int64 {
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	if seconds > 30*24*60*60 {
+		return time.Now().Unix() + seconds
+	}
+	return seconds
+}
+
+// memcacheError is an error reply sent by the server
+type memcacheError string
+
+func (e memcacheError) Error() string {
+	return "microcache: memcache: " + string(e)
+}
+
+func (e memcacheError) reply() {}
+
+var errMemcacheProtocol = errors.New("microcache: memcache: protocol error")
+
+// memcacheCommand flushes a command and reads the first line of its reply
+func memcacheCommand(c *poolConn) (string, error) {
+	if err := c.w.Flush(); err != nil {
+		return "", err
+	}
+	return readMemcacheLine(c.r)
+}
+
+// readMemcacheLine reads a reply line, returning error replies as memcacheError
+func readMemcacheLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return "", errMemcacheProtocol
+	}
+	line = line[:len(line)-2]
+	if line == "ERROR" || strings.HasPrefix(line, "CLIENT_ERROR") || strings.HasPrefix(line, "SERVER_ERROR") {
+		return "", memcacheError(line)
+	}
+	return line, nil
+}
+
+// memcacheRing distributes keys across servers by consistent hashing
+type memcacheRing struct {
+	pools  []*connPool
+	points []memcachePoint
+}
+
+type memcachePoint struct {
+	hash uint32
+	pool *connPool
+}
+
+// Number of points on the ring per server
+const memcacheReplicas = 160
+
+func (r *memcacheRing) add(p *connPool) {
+	r.pools = append(r.pools, p)
+	for i := 0; i < memcacheReplicas; i++ {
+		hash := crc32.ChecksumIEEE([]byte(p.addr + "-" + strconv.Itoa(i)))
+		r.points = append(r.points, memcachePoint{hash, p})
+	}
+}
+
+// pool returns the connection pool of the server responsible for a key
+func (r *memcacheRing) pool(key string) *connPool {
+	if len(r.points) == 0 {
+		return &connPool{}
+	}
+	hash := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= hash
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].pool
+}
@@ -0,0 +1,201 @@
+package microcache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMemcache is an in-process server implementing the subset of the memcached
+// text protocol used by DriverMemcache
+type fakeMemcache struct {
+	ln      net.Listener
+	mutex   sync.Mutex
+	data    map[string][]byte
+	exptime map[string]int
+	maxItem int
+}
+
+func newFakeMemcache(t *testing.T, maxItem int) *fakeMemcache {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeMemcache{ln: ln, data: map[string][]byte{}, exptime: map[string]int{}, maxItem: maxItem}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeMemcache) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			conn.Write([]byte("ERROR\r\n"))
+			continue
+		}
+		f.mutex.Lock()
+		switch args[0] {
+		case "set":
+			exptime, _ := strconv.Atoi(args[3])
+			n, _ := strconv.Atoi(args[4])
+			b := make([]byte, n+2)
+			io.ReadFull(r, b)
+			if n > f.maxItem {
+				conn.Write([]byte("SERVER_ERROR object too large for cache\r\n"))
+				break
+			}
+			f.data[args[1]] = b[:n]
+			f.exptime[args[1]] = exptime
+			conn.Write([]byte("STORED\r\n"))
+		case "get":
+			if b, ok := f.data[args[1]]; ok {
+				fmt.Fprintf(conn, "VALUE %s 0 %d\r\n%s\r\n", args[1], len(b), b)
+			}
+			conn.Write([]byte("END\r\n"))
+		case "delete":
+			if _, ok := f.data[args[1]]; ok {
+				delete(f.data, args[1])
+				conn.Write([]byte("DELETED\r\n"))
+			} else {
+				conn.Write([]byte("NOT_FOUND\r\n"))
+			}
+		case "stats":
+			fmt.Fprintf(conn, "STAT pid 1\r\nSTAT curr_items %d\r\nEND\r\n", len(f.data))
+		default:
+			conn.Write([]byte("ERROR\r\n"))
+		}
+		f.mutex.Unlock()
+	}
+}
+
+func (f *fakeMemcache) count(prefix string) (n int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for key := range f.data {
+		if strings.HasPrefix(key, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+// DriverMemcache should distribute entries across servers
+func TestDriverMemcache(t *testing.T) {
+	servers := []*fakeMemcache{newFakeMemcache(t, 1<<20), newFakeMemcache(t, 1<<20)}
+	var addrs []string
+	for _, s := range servers {
+		defer s.ln.Close()
+		addrs = append(addrs, s.ln.Addr().String())
+	}
+	d := NewDriverMemcache(DriverMemcacheConfig{Servers: addrs, Prefix: "test:"})
+	cache := New(Config{
+		TTL:          30 * time.Second,
+		StaleIfError: 60 * time.Second,
+		Driver:       d,
+		Exposed:      true,
+	})
+	defer cache.Stop()
+	handler := cache.Middleware(http.HandlerFunc(noopSuccessHandler))
+	var urls []string
+	for i := 0; i < 50; i++ {
+		urls = append(urls, "/"+strconv.Itoa(i))
+	}
+	batchGet(handler, urls)
+	for _, url := range urls {
+		if getResponse(handler, url).Header().Get("microcache") != "HIT" {
+			t.Fatalf("DriverMemcache should cache %s", url)
+		}
+	}
+	for i, s := range servers {
+		if n := s.count("test:obj:"); n < 5 {
+			t.Fatalf("Server %d should store a share of the response objects, got %d", i, n)
+		}
+		s.mutex.Lock()
+		for key, exptime := range s.exptime {
+			if strings.HasPrefix(key, "test:obj:") && exptime != 90 {
+				t.Fatalf("Response object should expire after ttl and stale window, got %ds", exptime)
+			}
+		}
+		s.mutex.Unlock()
+	}
+	if d.GetSize() != 100 {
+		t.Fatalf("DriverMemcache should report items on all servers, got %d", d.GetSize())
+	}
+
+	// Keys map to the same server regardless of server order
+	d2 := NewDriverMemcache(DriverMemcacheConfig{Servers: []string{addrs[1], addrs[0]}, Prefix: "test:"})
+	for _, url := range urls {
+		key := d.responseKey(url)
+		if d.ring.pool(key).addr != d2.ring.pool(key).addr {
+			t.Fatal("DriverMemcache should hash keys consistently")
+		}
+	}
+
+	cache.PurgeAll()
+	if getResponse(handler, "/1").Header().Get("microcache") != "MISS" {
+		t.Fatal("DriverMemcache should respect purges")
+	}
+	r, _ := http.NewRequest("GET", "/1", nil)
+	reqHash := getRequestHash(cache, r)
+	req, _ := d.GetRequestOpts(reqHash)
+	objHash := req.getObjectHash(reqHash, r)
+	if err := d.Remove(objHash); err != nil {
+		t.Fatal(err)
+	}
+	if res, _ := d.Get(objHash); res.found {
+		t.Fatal("DriverMemcache should remove response objects")
+	}
+}
+
+// Responses too large for memcached should not be cached
+func TestDriverMemcacheItemSize(t *testing.T) {
+	server := newFakeMemcache(t, 1000)
+	defer server.ln.Close()
+	for _, maxItemSize := range []int{0, 500} {
+		d := NewDriverMemcache(DriverMemcacheConfig{
+			Servers:     []string{server.ln.Addr().String()},
+			Prefix:      "size" + strconv.Itoa(maxItemSize) + ":",
+			MaxItemSize: maxItemSize,
+		})
+		cache := New(Config{
+			TTL:     30 * time.Second,
+			Driver:  d,
+			Exposed: true,
+		})
+		defer cache.Stop()
+		var backend int
+		handler := cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			backend++
+			w.Write([]byte(strings.Repeat("a", 1200)))
+		}))
+		batchGet(handler, []string{"/", "/", "/"})
+		if backend != 3 || server.count("size"+strconv.Itoa(maxItemSize)+":obj:") != 0 {
+			t.Fatalf("Responses too large for memcached should not be cached, got %d backend requests", backend)
+		}
+		r, _ := http.NewRequest("GET", "/", nil)
+		if req, _ := d.GetRequestOpts(getRequestHash(cache, r)); !req.nocache {
+			t.Fatal("Requests with responses too large for memcached should not be cached")
+		}
+	}
+}
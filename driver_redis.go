@@ -0,0 +1,354 @@
+package microcache
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// DriverRedis is a driver implementation storing entries in a server speaking
+// the Redis protocol (RESP) so that the cache can be shared by many instances.
+//
+// DriverRedis implements DriverV2 so that the middleware bounds commands by
+// Config.DriverTimeout and reports failures.
+//
+// DriverRedis implements IterableDriver by enumerating keys with SCAN, so that
+// purges and PurgeAll apply to every instance sharing the server.
+//
+// Response objects expire natively once their stale windows have passed.
+// Request options are kept for RequestOptsTTL so that purges remain in effect
+// for the lifetime of their response objects.
+type DriverRedis struct {
+	// Prefix namespaces the keys of this cache
+	Prefix string
+
+	// RequestOptsTTL specifies how long request options are stored.
+	// It should exceed the longest ttl plus stale window of any response.
+	RequestOptsTTL time.Duration
+
+	pool *connPool
+}
+
+// DriverRedisConfig configures a DriverRedis
+type DriverRedisConfig struct {
+	// Addr is the address of the server (host:port)
+	Addr string
+
+	// Password is sent with AUTH if set
+	Password string
+
+	// DB is selected with SELECT if set
+	DB int
+
+	// Prefix namespaces the keys of this cache
+	// Default: "microcache:"
+	Prefix string
+
+	// PoolSize is the maximum number of idle connections kept open
+	// Default: 10
+	PoolSize int
+
+	// Timeout bounds dialing and each command
+	// Default: 1s
+	Timeout time.Duration
+
+	// RequestOptsTTL specifies how long request options are stored
+	// Default: 24h
+	RequestOptsTTL time.Duration
+}
+
+// NewDriverRedis returns a Redis driver. Connections are opened on demand.
+func NewDriverRedis(c DriverRedisConfig) DriverRedis {
+	if c.Prefix == "" {
+		c.Prefix = "microcache:"
+	}
+	if c.PoolSize <= 0 {
+		c.PoolSize = 10
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = time.Second
+	}
+	if c.RequestOptsTTL <= 0 {
+		c.RequestOptsTTL = 24 * time.Hour
+	}
+	return DriverRedis{
+		Prefix:         c.Prefix,
+		RequestOptsTTL: c.RequestOptsTTL,
+		pool: newConnPool(c.Addr, c.PoolSize, c.Timeout, func(conn *poolConn) error {
+			if c.Password != "" {
+				if _, err := redisCommand(conn, "AUTH", c.Password); err != nil {
+					return err
+				}
+			}
+			if c.DB != 0 {
+				if _, err := redisCommand(conn, "SELECT", strconv.Itoa(c.DB)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}),
+	}
+}
+
+func (d DriverRedis) requestKey(hash string) string {
+	return d.Prefix + "req:" + hex.EncodeToString([]byte(hash))
+}
+
+func (d DriverRedis) responseKey(hash string) string {
+	return d.Prefix + "obj:" + hex.EncodeToString([]byte(hash))
+}
+
+func (d DriverRedis) SetRequestOpts(hash string, req RequestOpts) error {
+	return d.SetRequestOptsContext(context.Background(), hash, req)
+}
+
+func (d DriverRedis) GetRequestOpts(hash string) (RequestOpts, bool) {
+	req, collision, _ := d.GetRequestOptsContext(context.Background(), hash)
+	return req, collision
+}
+
+// Set stores a response object until its stale windows have passed
+func (d DriverRedis) Set(hash string, res Response) error {
+	return d.SetContext(context.Background(), hash, res)
+}
+
+func (d DriverRedis) Get(hash string) (Response, bool) {
+	res, collision, _ := d.GetContext(context.Background(), hash)
+	return res, collision
+}
+
+func (d DriverRedis) Remove(hash string) error {
+	return d.RemoveContext(context.Background(), hash)
+}
+
+// GetSize returns the number of response objects stored under Prefix.
+// Keys are counted with SCAN so this is expensive for large caches.
+func (d DriverRedis) GetSize() int {
+	n, _ := d.GetSizeContext(context.Background())
+	return n
+}
+
+func (d DriverRedis) SetRequestOptsContext(ctx context.Context, hash string, req RequestOpts) error {
+	req.hash = hash
+	b, err := req.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = d.do(ctx, "SET", d.requestKey(hash), string(b), "PX", strconv.FormatInt(d.RequestOptsTTL.Milliseconds(), 10))
+	return err
+}
+
+func (d DriverRedis) GetRequestOptsContext(ctx context.Context, hash string) (req RequestOpts, collision bool, err error) {
+	reply, err := d.do(ctx, "GET", d.requestKey(hash))
+	if b, ok := reply.([]byte); ok && err == nil {
+		if err = req.UnmarshalBinary(b); err != nil {
+			return RequestOpts{}, false, err
+		}
+	}
+	return req, false, err
+}
+
+func (d DriverRedis) SetContext(ctx context.Context, hash string, res Response) error {
+	ttl := time.Until(res.expires) + res.stale
+	if ttl < time.Millisecond {
+		return d.RemoveContext(ctx, hash)
+	}
+	res.hash = hash
+	b, err := res.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = d.do(ctx, "SET", d.responseKey(hash), string(b), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+func (d DriverRedis) GetContext(ctx context.Context, hash string) (res Response, collision bool, err error) {
+	reply, err := d.do(ctx, "GET", d.responseKey(hash))
+	if b, ok := reply.([]byte); ok && err == nil {
+		if err = res.UnmarshalBinary(b); err != nil {
+			return Response{}, false, err
+		}
+	}
+	return res, false, err
+}
+
+func (d DriverRedis) RemoveContext(ctx context.Context, hash string) error {
+	_, err := d.do(ctx, "DEL", d.responseKey(hash))
+	return err
+}
+
+func (d DriverRedis) GetSizeContext(ctx context.Context) (int, error) {
+	var n int
+	err := d.scan(ctx, d.Prefix+"obj:*", func(keys []string) bool {
+		n += len(keys)
+		return true
+	})
+	return n, err
+}
+
+// RangeRequestOpts calls f for each request options entry stored under Prefix.
+// Keys are enumerated with SCAN so this is expensive for large caches.
+// Entries which expire or can not be read while ranging are skipped.
+func (d DriverRedis) RangeRequestOpts(f func(hash string, req RequestOpts) bool) {
+	ctx := context.Background()
+	d.scan(ctx, d.Prefix+"req:*", func(keys []string) bool {
+		for _, key := range keys {
+			hash, err := hex.DecodeString(key[len(d.Prefix+"req:"):])
+			if err != nil {
+				continue
+			}
+			req, _, err := d.GetRequestOptsContext(ctx, string(hash))
+			if err != nil || !req.found {
+				continue
+			}
+			if !f(string(hash), req) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Range calls f for each response object stored under Prefix like RangeRequestOpts
+func (d DriverRedis) Range(f func(hash string, res Response) bool) {
+	ctx := context.Background()
+	d.scan(ctx, d.Prefix+"obj:*", func(keys []string) bool {
+		for _, key := range keys {
+			hash, err := hex.DecodeString(key[len(d.Prefix+"obj:"):])
+			if err != nil {
+				continue
+			}
+			res, _, err := d.GetContext(ctx, string(hash))
+			if err != nil || !res.found {
+				continue
+			}
+			if !f(string(hash), res) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// scan calls f with the keys matching pattern in batches until f returns false
+func (d DriverRedis) scan(ctx context.Context, pattern string, f func(keys []string) bool) error {
+	cursor := "0"
+	for {
+		reply, err := d.do(ctx, "SCAN", cursor, "MATCH", pattern, "COUNT", "1000")
+		if err != nil {
+			return err
+		}
+		r, ok := reply.([]interface{})
+		if !ok || len(r) != 2 {
+			return errRedisProtocol
+		}
+		next, _ := r[0].([]byte)
+		values, _ := r[1].([]interface{})
+		keys := make([]string, 0, len(values))
+		for _, v := range values {
+			if key, ok := v.([]byte); ok {
+				keys = append(keys, string(key))
+			}
+		}
+		if !f(keys) {
+			return nil
+		}
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return nil
+		}
+	}
+}
+
+// redisError is an error reply sent by the server
+type redisError string
+
+func (e redisError) Error() string {
+	return "microcache: redis: " + string(e)
+}
+
+func (e redisError) reply() {}
+
+var errRedisProtocol = errors.New("microcache: redis: protocol error")
+
+// do sends a command and returns its reply
+func (d DriverRedis) do(ctx context.Context, args ...string) (reply interface{}, err error) {
+	err = d.pool.do(ctx, func(c *poolConn) error {
+		reply, err = redisCommand(c, args...)
+		return err
+	})
+	return reply, err
+}
+
+func redisCommand(c *poolConn, args ...string) (interface{}, error) {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	return readRedisReply(c.r)
+}
+
+// readRedisReply reads a RESP value. Simple strings and bulk strings are
+// returned as []byte, integers as int64 and arrays as []interface{}.
+// Error replies are returned as redisError.
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errRedisProtocol
+	}
+	typ, line := line[0], line[1:len(line)-2]
+	switch typ {
+	case '+':
+		return []byte(line), nil
+	case '-':
+		return nil, redisError(line)
+	case ':':
+		n, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return nil, errRedisProtocol
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < -1 {
+			return nil, errRedisProtocol
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < -1 {
+			return nil, errRedisProtocol
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], err = readRedisReply(r); err != nil {
+				if _, ok := err.(redisError); !ok {
+					return nil, err
+				}
+			}
+		}
+		return values, nil
+	}
+	return nil, errRedisProtocol
+}
@@ -0,0 +1,225 @@
+package microcache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in-process server implementing the subset of RESP commands
+// used by DriverRedis
+type fakeRedis struct {
+	ln    net.Listener
+	mutex sync.Mutex
+	data  map[string]string
+	ttls  map[string]int
+	conns int
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{ln: ln, data: map[string]string{}, ttls: map[string]int{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			f.mutex.Lock()
+			f.conns++
+			f.mutex.Unlock()
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		v, err := readRedisReply(r)
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, arg := range v.([]interface{}) {
+			args = append(args, string(arg.([]byte)))
+		}
+		conn.Write([]byte(f.exec(args)))
+	}
+}
+
+func (f *fakeRedis) exec(args []string) string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "AUTH":
+		if args[1] != "secret" {
+			return "-WRONGPASS invalid password\r\n"
+		}
+		return "+OK\r\n"
+	case "SELECT":
+		return "+OK\r\n"
+	case "SET":
+		f.data[args[1]] = args[2]
+		if len(args) == 5 && args[3] == "PX" {
+			f.ttls[args[1]], _ = strconv.Atoi(args[4])
+		}
+		return "+OK\r\n"
+	case "GET":
+		v, ok := f.data[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	case "DEL":
+		_, ok := f.data[args[1]]
+		delete(f.data, args[1])
+		if ok {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	case "SCAN":
+		var keys []string
+		for key := range f.data {
+			if ok, _ := path.Match(args[3], key); ok {
+				keys = append(keys, fmt.Sprintf("$%d\r\n%s\r\n", len(key), key))
+			}
+		}
+		return fmt.Sprintf("*2\r\n$1\r\n0\r\n*%d\r\n%s", len(keys), strings.Join(keys, ""))
+	}
+	return "-ERR unknown command\r\n"
+}
+
+// DriverRedis should share entries across caches through a RESP server
+func TestDriverRedis(t *testing.T) {
+	server := newFakeRedis(t)
+	defer server.ln.Close()
+	var newCache = func(prefix string) *microcache {
+		return New(Config{
+			TTL:                  30 * time.Second,
+			StaleIfError:         60 * time.Second,
+			StaleWhileRevalidate: 20 * time.Second,
+			Driver: NewDriverRedis(DriverRedisConfig{
+				Addr:     server.ln.Addr().String(),
+				Password: "secret",
+				DB:       2,
+				Prefix:   prefix,
+			}),
+			Exposed: true,
+		})
+	}
+	var status = func(cache *microcache, url string) string {
+		handler := cache.Middleware(http.HandlerFunc(noopSuccessHandler))
+		return getResponse(handler, url).Header().Get("microcache")
+	}
+
+	cache := newCache("")
+	defer cache.Stop()
+	if status(cache, "/a") != "MISS" || status(cache, "/a") != "HIT" {
+		t.Fatal("DriverRedis should cache responses")
+	}
+	if cache.Driver.GetSize() != 1 {
+		t.Fatal("DriverRedis should report 1 response object")
+	}
+	server.mutex.Lock()
+	for key, ttl := range server.ttls {
+		if strings.HasPrefix(key, "microcache:obj:") && (ttl > 90000 || ttl < 89000) {
+			t.Fatalf("Response object should expire after ttl and stale window, got %dms", ttl)
+		}
+		if strings.HasPrefix(key, "microcache:req:") && ttl != 86400000 {
+			t.Fatalf("Request options should expire after RequestOptsTTL, got %dms", ttl)
+		}
+	}
+	if len(server.ttls) != 2 || server.conns != 1 {
+		t.Fatalf("DriverRedis should reuse pooled connections, got %d", server.conns)
+	}
+	server.mutex.Unlock()
+
+	// Shared by caches with the same prefix
+	shared := newCache("")
+	defer shared.Stop()
+	if status(shared, "/a") != "HIT" {
+		t.Fatal("DriverRedis should share responses between caches")
+	}
+	other := newCache("other:")
+	defer other.Stop()
+	if status(other, "/a") != "MISS" {
+		t.Fatal("DriverRedis should namespace keys by prefix")
+	}
+
+	// Purge
+	status(cache, "/b")
+	if n, err := cache.PurgePrefix("/b"); n != 1 || err != nil {
+		t.Fatalf("DriverRedis should be iterable, got %d %v", n, err)
+	}
+	status(cache, "/b")
+	cache.PurgeAll()
+	fresh := newCache("")
+	defer fresh.Stop()
+	if status(fresh, "/a") != "MISS" || status(shared, "/b") != "MISS" {
+		t.Fatal("DriverRedis purges should apply to every cache sharing the server")
+	}
+	if status(cache, "/a") != "HIT" {
+		t.Fatal("DriverRedis should cache responses after purges")
+	}
+
+	// Authentication failure
+	d := NewDriverRedis(DriverRedisConfig{Addr: server.ln.Addr().String(), Password: "wrong"})
+	if err := d.Set("a", Response{expires: time.Now().Add(time.Second)}); err == nil {
+		t.Fatal("DriverRedis should report authentication failure")
+	}
+}
+
+// DriverRedis should time out unresponsive servers
+func TestDriverRedisTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	d := NewDriverRedis(DriverRedisConfig{Addr: ln.Addr().String(), Timeout: 50 * time.Millisecond})
+	start := time.Now()
+	if res, _ := d.Get("a"); res.found {
+		t.Fatal("DriverRedis should miss when the server does not respond")
+	}
+	if err := d.SetRequestOpts("a", RequestOpts{}); err == nil {
+		t.Fatal("DriverRedis should return timeout errors")
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("DriverRedis should respect Timeout")
+	}
+
+	// Context deadlines shorter than Timeout
+	d = NewDriverRedis(DriverRedisConfig{Addr: ln.Addr().String(), Timeout: 10 * time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if _, _, err := d.GetContext(ctx, "a"); err == nil {
+		t.Fatal("DriverRedis should return errors when the context deadline passes")
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("DriverRedis should respect context deadlines")
+	}
+}
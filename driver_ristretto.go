@@ -1,6 +1,8 @@
 package microcache
 
 import (
+	"sort"
+	"sync"
 	"unsafe"
 
 	"github.com/dgraph-io/ristretto"
@@ -12,8 +14,51 @@ var (
 )
 
 // DriverRistretto is a driver implementation using github.com/dgraph-io/ristretto
+// Ristretto can not enumerate its keys so they are tracked in a shadow index.
 type DriverRistretto struct {
 	Cache *ristretto.Cache[string, any]
+
+	evicted *evictListeners
+	keys    *ristrettoKeys
+}
+
+// ristrettoKeys is a shadow index of the keys stored in a ristretto cache.
+// Keys are added before each set so that an asynchronous rejection, which
+// removes the key, can not precede its addition. Keys of sets which are not yet
+// applied are skipped while ranging.
+type ristrettoKeys struct {
+	mutex     sync.Mutex
+	requests  map[string]bool
+	responses map[string]bool
+}
+
+// add adds a key and reports whether it was not yet present
+func (k *ristrettoKeys) add(keys map[string]bool, hash string) bool {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if keys[hash] {
+		return false
+	}
+	keys[hash] = true
+	return true
+}
+
+func (k *ristrettoKeys) remove(keys map[string]bool, hash string) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	delete(keys, hash)
+}
+
+func (k *ristrettoKeys) list(keys map[string]bool) []string {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	list := make([]string, 0, len(keys))
+	for hash := range keys {
+		list = append(list, hash)
+	}
+	// Stable order for paging through entries
+	sort.Strings(list)
+	return list
 }
 
 func calculateResponseCost(res Response) int64 {
@@ -55,22 +100,38 @@ func calculateRequestOptCost(req RequestOpts) int64 {
 // Estimating this on the higher side is better.
 // size determines the maximum number of bytes in the cache.
 func NewDriverRistretto(requests, size int64) DriverRistretto {
+	evicted := &evictListeners{}
+	keys := &ristrettoKeys{requests: map[string]bool{}, responses: map[string]bool{}}
+	onEvict := func(item *ristretto.Item[any]) {
+		switch v := item.Value.(type) {
+		case Response:
+			keys.remove(keys.responses, v.hash)
+			evicted.notify(v.hash)
+		case RequestOpts:
+			keys.remove(keys.requests, v.hash)
+		}
+	}
 	cache, err := ristretto.NewCache[string, any](&ristretto.Config[string, any]{
 		NumCounters: requests * 10,
 		MaxCost:     size,
 		BufferItems: 64,
 		Metrics:     true,
+		OnEvict:     onEvict,
+		OnReject:    onEvict,
 	})
 	if err != nil {
 		panic(err)
 	}
 
-	return DriverRistretto{cache}
+	return DriverRistretto{cache, evicted, keys}
 }
 
 func (d DriverRistretto) SetRequestOpts(hash string, req RequestOpts) error {
 	req.hash = hash
-	d.Cache.Set(hash, req, calculateRequestOptCost(req))
+	added := d.keys != nil && d.keys.add(d.keys.requests, hash)
+	if !d.Cache.Set(hash, req, calculateRequestOptCost(req)) && added {
+		d.keys.remove(d.keys.requests, hash)
+	}
 	return nil
 }
 
@@ -92,7 +153,10 @@ func (d DriverRistretto) GetRequestOpts(hash string) (req RequestOpts, collision
 
 func (d DriverRistretto) Set(hash string, res Response) error {
 	res.hash = hash
-	d.Cache.Set(hash, res, calculateResponseCost(res))
+	added := d.keys != nil && d.keys.add(d.keys.responses, hash)
+	if !d.Cache.Set(hash, res, calculateResponseCost(res)) && added {
+		d.keys.remove(d.keys.responses, hash)
+	}
 	return nil
 }
 
@@ -114,9 +178,48 @@ func (d DriverRistretto) Get(hash string) (res Response, collision bool) {
 
 func (d DriverRistretto) Remove(hash string) error {
 	d.Cache.Del(hash)
+	if d.keys != nil {
+		d.keys.remove(d.keys.responses, hash)
+	}
 	return nil
 }
 
 func (d DriverRistretto) GetSize() int {
 	return int(d.Cache.Metrics.KeysAdded() - d.Cache.Metrics.KeysEvicted())
 }
+
+func (d DriverRistretto) OnEvict(f func(hash string)) {
+	if d.evicted != nil {
+		d.evicted.add(f)
+	}
+}
+
+func (d DriverRistretto) RangeRequestOpts(f func(hash string, req RequestOpts) bool) {
+	if d.keys == nil {
+		return
+	}
+	for _, hash := range d.keys.list(d.keys.requests) {
+		req, collision := d.GetRequestOpts(hash)
+		if collision || !req.found {
+			continue
+		}
+		if !f(hash, req) {
+			return
+		}
+	}
+}
+
+func (d DriverRistretto) Range(f func(hash string, res Response) bool) {
+	if d.keys == nil {
+		return
+	}
+	for _, hash := range d.keys.list(d.keys.responses) {
+		res, collision := d.Get(hash)
+		if collision || !res.found {
+			continue
+		}
+		if !f(hash, res) {
+			return
+		}
+	}
+}
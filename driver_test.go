@@ -1,8 +1,15 @@
 package microcache
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 // Remove should work as expected
@@ -47,3 +54,245 @@ func TestEmptyInit(t *testing.T) {
 	testDriver("ARC", NewDriverARC(0))
 	testDriver("LRU", NewDriverLRU(0))
 }
+
+// IterableDriver should enumerate request options and response objects
+func TestIterableDriver(t *testing.T) {
+	var testDriver = func(name string, d IterableDriver) {
+		cache := New(Config{TTL: 30 * time.Second, Driver: d})
+		defer cache.Stop()
+		handler := cache.Middleware(http.HandlerFunc(noopSuccessHandler))
+		batchGet(handler, []string{"/a", "/b", "/c"})
+		if r, ok := d.(DriverRistretto); ok {
+			r.Cache.Wait()
+		}
+		var paths []string
+		d.RangeRequestOpts(func(hash string, req RequestOpts) bool {
+			paths = append(paths, req.path)
+			return true
+		})
+		sort.Strings(paths)
+		if strings.Join(paths, ",") != "/a,/b,/c" {
+			t.Fatalf("%s Driver should range over request options, got %v", name, paths)
+		}
+		var n int
+		d.Range(func(hash string, res Response) bool {
+			if obj, _ := d.Get(hash); !obj.found || res.status != 200 {
+				t.Fatalf("%s Driver ranged over invalid response %s", name, hash)
+			}
+			n++
+			return true
+		})
+		if n != 3 {
+			t.Fatalf("%s Driver should range over 3 responses, got %d", name, n)
+		}
+		n = 0
+		d.Range(func(hash string, res Response) bool {
+			n++
+			d.Remove(hash)
+			return false
+		})
+		if r, ok := d.(DriverRistretto); ok {
+			r.Cache.Wait()
+		}
+		d.Range(func(hash string, res Response) bool {
+			n++
+			return true
+		})
+		if n != 3 {
+			t.Fatalf("%s Driver should stop ranging when f returns false", name)
+		}
+	}
+	testDriver("ARC", NewDriverARC(10))
+	testDriver("LRU", NewDriverLRU(10))
+	testDriver("Ristretto", NewDriverRistretto(100, 1e6))
+}
+
+// DriverRistretto should range in a stable order and drop rejected keys
+func TestDriverRistrettoKeys(t *testing.T) {
+	d := NewDriverRistretto(100, 1e6)
+	for i := 0; i < 20; i++ {
+		d.SetRequestOpts(strconv.Itoa(i), RequestOpts{found: true})
+	}
+	d.Set("big", Response{found: true, body: make([]byte, 2e6)})
+	d.Cache.Wait()
+	var list = func() (hashes []string) {
+		d.RangeRequestOpts(func(hash string, req RequestOpts) bool {
+			hashes = append(hashes, hash)
+			return true
+		})
+		return hashes
+	}
+	first := list()
+	if len(first) != 20 || !sort.StringsAreSorted(first) || strings.Join(list(), ",") != strings.Join(first, ",") {
+		t.Fatalf("DriverRistretto should range in a stable order, got %v", first)
+	}
+	if len(d.keys.list(d.keys.responses)) != 0 {
+		t.Fatal("DriverRistretto should drop keys of rejected entries")
+	}
+}
+
+// flakyDriver is a DriverV2 which fails or stalls on demand
+type flakyDriver struct {
+	DriverLRU
+	err   error
+	delay time.Duration
+
+	// reqErr fails reads of request options only
+	reqErr error
+}
+
+func (d *flakyDriver) wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case <-time.After(d.delay):
+		return d.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *flakyDriver) SetRequestOptsContext(ctx context.Context, hash string, req RequestOpts) error {
+	if err := d.wait(ctx); err != nil {
+		return err
+	}
+	return d.SetRequestOpts(hash, req)
+}
+
+func (d *flakyDriver) GetRequestOptsContext(ctx context.Context, hash string) (RequestOpts, bool, error) {
+	if err := d.wait(ctx); err != nil {
+		return RequestOpts{}, false, err
+	}
+	if d.reqErr != nil {
+		return RequestOpts{}, false, d.reqErr
+	}
+	req, collision := d.GetRequestOpts(hash)
+	return req, collision, nil
+}
+
+func (d *flakyDriver) SetContext(ctx context.Context, hash string, res Response) error {
+	if err := d.wait(ctx); err != nil {
+		return err
+	}
+	return d.Set(hash, res)
+}
+
+func (d *flakyDriver) GetContext(ctx context.Context, hash string) (Response, bool, error) {
+	if err := d.wait(ctx); err != nil {
+		return Response{}, false, err
+	}
+	res, collision := d.Get(hash)
+	return res, collision, nil
+}
+
+func (d *flakyDriver) RemoveContext(ctx context.Context, hash string) error {
+	if err := d.wait(ctx); err != nil {
+		return err
+	}
+	return d.Remove(hash)
+}
+
+func (d *flakyDriver) GetSizeContext(ctx context.Context) (int, error) {
+	if err := d.wait(ctx); err != nil {
+		return 0, err
+	}
+	return d.GetSize(), nil
+}
+
+// Driver errors and timeouts should be treated as cache misses
+func TestDriverV2(t *testing.T) {
+	d := &flakyDriver{DriverLRU: NewDriverLRU(10)}
+	testMonitor := &monitorFunc{interval: 100 * time.Second, logFunc: func(Stats) {}}
+	cache := New(Config{
+		TTL:           30 * time.Second,
+		Driver:        d,
+		DriverTimeout: 20 * time.Millisecond,
+		Monitor:       testMonitor,
+		Exposed:       true,
+	})
+	defer cache.Stop()
+	handler := cache.Middleware(http.HandlerFunc(noopSuccessHandler))
+	var status = func() string {
+		res := getResponse(handler, "/")
+		if res.Code != 200 {
+			t.Fatalf("Driver errors should not fail requests, got %d", res.Code)
+		}
+		return res.Header().Get("microcache")
+	}
+	if status() != "MISS" || status() != "HIT" || testMonitor.getDriverErrors() != 0 {
+		t.Fatal("DriverV2 should be used to cache responses")
+	}
+
+	// Errors
+	d.err = errors.New("unavailable")
+	if status() != "MISS" {
+		t.Fatal("Driver errors should be treated as cache misses")
+	}
+	if n := testMonitor.getDriverErrors(); n != 1 {
+		t.Fatalf("Driver errors should be reported to the monitor, got %d", n)
+	}
+	r, _ := http.NewRequest("GET", "/", nil)
+	if cache.Lookup(r).Found || testMonitor.getDriverErrors() != 2 {
+		t.Fatal("Lookup should report driver errors")
+	}
+
+	// Timeouts
+	d.err = nil
+	d.delay = time.Second
+	start := time.Now()
+	if status() != "MISS" {
+		t.Fatal("Driver timeouts should be treated as cache misses")
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatal("Driver calls should be bounded by DriverTimeout")
+	}
+	if n := testMonitor.getDriverErrors(); n != 3 {
+		t.Fatalf("Driver timeouts should be reported to the monitor, got %d", n)
+	}
+
+	// Adapter
+	if _, ok := NewDriverV2(d).(*flakyDriver); !ok {
+		t.Fatal("NewDriverV2 should return drivers implementing DriverV2")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := NewDriverV2(NewDriverLRU(10)).GetContext(ctx, "a"); err != context.Canceled {
+		t.Fatal("Adapted drivers should respect cancelled contexts")
+	}
+}
+
+// Failed reads of request options should not replace stored purge times
+func TestDriverV2ReadError(t *testing.T) {
+	d := &flakyDriver{DriverLRU: NewDriverLRU(10)}
+	cache := New(Config{
+		TTL:     30 * time.Second,
+		Driver:  d,
+		Exposed: true,
+	})
+	defer cache.Stop()
+	var body = "a"
+	handler := cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte(body))
+	}))
+	var get = func(lang string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", "/a", nil)
+		r.Header.Set("Accept-Language", lang)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	get("en")
+	get("fr")
+	cache.Purge("/a")
+	body = "b"
+	d.reqErr = errors.New("unavailable")
+	if res := get("en"); res.Header().Get("microcache") != "MISS" || res.Body.String() != "b" {
+		t.Fatal("Failed reads of request options should pass requests through")
+	}
+	d.reqErr = nil
+	if res := get("fr"); res.Header().Get("microcache") == "HIT" || res.Body.String() != "b" {
+		t.Fatal("Purged responses should not be served after a failed read")
+	}
+}
@@ -0,0 +1,249 @@
+package microcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrClosed is returned by writes to a DriverTiered whose write-back worker
+// has been stopped by Close
+var ErrClosed = errors.New("microcache: driver closed")
+
+// DriverTiered is a driver implementation composing a small, fast L1 driver
+// with a larger L2 driver, such as a DriverLRU in front of a DriverDisk or
+// DriverRedis.
+//
+//	microcache.DriverTiered{
+//		L1: microcache.NewDriverLRU(1e3),
+//		L2: microcache.NewDriverRedis(microcache.DriverRedisConfig{Addr: "localhost:6379"}),
+//	}
+//
+// Reads check L1 and fall back to L2, promoting entries found in L2 to L1.
+// Writes go to both tiers. Removals are applied to both tiers.
+//
+// When L2 is shared between instances, entries in the L1 of other instances are
+// not invalidated by purges until they are evicted from L1.
+//
+// DriverTiered implements DriverV2 and passes contexts and errors through to
+// tiers which implement it. It is used as an IterableDriver when L2 is one.
+type DriverTiered struct {
+	L1 Driver
+	L2 Driver
+
+	// writeBack applies writes to L2 in the background
+	writeBack *tieredWriter
+}
+
+// NewDriverTiered returns a tiered driver. If writeBack is true, writes to L2
+// are applied in the background by a single worker in the order they were made
+// rather than before Set returns. Errors writing to L2 are not reported.
+// Writes wait for space in the queue until their context is done.
+// Remove waits for pending writes so that removed entries are not written back.
+// Close stops the worker.
+func NewDriverTiered(l1, l2 Driver, writeBack bool) DriverTiered {
+	d := DriverTiered{L1: l1, L2: l2}
+	if writeBack {
+		d.writeBack = newTieredWriter(1024)
+	}
+	return d
+}
+
+// tieredWriter applies writes to L2 in order. Writers block while the queue is full.
+type tieredWriter struct {
+	queue  chan func()
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newTieredWriter(size int) *tieredWriter {
+	w := &tieredWriter{queue: make(chan func(), size), closed: make(chan struct{})}
+	go func() {
+		for {
+			select {
+			case f := <-w.queue:
+				f()
+			case <-w.closed:
+				return
+			}
+		}
+	}()
+	return w
+}
+
+// enqueue queues a write unless ctx is done before the queue has space
+func (w *tieredWriter) enqueue(ctx context.Context, f func()) error {
+	select {
+	case <-w.closed:
+		return ErrClosed
+	default:
+	}
+	select {
+	case w.queue <- f:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-w.closed:
+		return ErrClosed
+	}
+}
+
+// wait blocks until the writes queued before it have been applied
+func (w *tieredWriter) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	if err := w.enqueue(ctx, func() { close(done) }); err != nil {
+		return err
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-w.closed:
+		return ErrClosed
+	}
+}
+
+// close stops the worker, discarding writes which have not been applied
+func (w *tieredWriter) close() {
+	w.once.Do(func() {
+		close(w.closed)
+	})
+}
+
+func (d DriverTiered) SetRequestOpts(hash string, req RequestOpts) error {
+	return d.SetRequestOptsContext(context.Background(), hash, req)
+}
+
+func (d DriverTiered) GetRequestOpts(hash string) (RequestOpts, bool) {
+	req, collision, _ := d.GetRequestOptsContext(context.Background(), hash)
+	return req, collision
+}
+
+func (d DriverTiered) Set(hash string, res Response) error {
+	return d.SetContext(context.Background(), hash, res)
+}
+
+func (d DriverTiered) Get(hash string) (Response, bool) {
+	res, collision, _ := d.GetContext(context.Background(), hash)
+	return res, collision
+}
+
+func (d DriverTiered) Remove(hash string) error {
+	return d.RemoveContext(context.Background(), hash)
+}
+
+// GetSize returns the number of response objects in L2, which holds every
+// response object stored in L1
+func (d DriverTiered) GetSize() int {
+	return d.L2.GetSize()
+}
+
+func (d DriverTiered) SetRequestOptsContext(ctx context.Context, hash string, req RequestOpts) error {
+	if err := NewDriverV2(d.L1).SetRequestOptsContext(ctx, hash, req); err != nil {
+		return err
+	}
+	l2 := NewDriverV2(d.L2)
+	if d.writeBack != nil {
+		return d.writeBack.enqueue(ctx, func() { l2.SetRequestOptsContext(context.Background(), hash, req) })
+	}
+	return l2.SetRequestOptsContext(ctx, hash, req)
+}
+
+func (d DriverTiered) GetRequestOptsContext(ctx context.Context, hash string) (RequestOpts, bool, error) {
+	l1 := NewDriverV2(d.L1)
+	req, collision, err := l1.GetRequestOptsContext(ctx, hash)
+	if err == nil && req.found && !collision {
+		return req, false, nil
+	}
+	req, collision, err = NewDriverV2(d.L2).GetRequestOptsContext(ctx, hash)
+	if err == nil && req.found && !collision {
+		l1.SetRequestOptsContext(ctx, hash, req)
+	}
+	return req, collision, err
+}
+
+func (d DriverTiered) SetContext(ctx context.Context, hash string, res Response) error {
+	if err := NewDriverV2(d.L1).SetContext(ctx, hash, res); err != nil {
+		return err
+	}
+	l2 := NewDriverV2(d.L2)
+	if d.writeBack != nil {
+		return d.writeBack.enqueue(ctx, func() { l2.SetContext(context.Background(), hash, res) })
+	}
+	return l2.SetContext(ctx, hash, res)
+}
+
+func (d DriverTiered) GetContext(ctx context.Context, hash string) (Response, bool, error) {
+	l1 := NewDriverV2(d.L1)
+	res, collision, err := l1.GetContext(ctx, hash)
+	if err == nil && res.found && !collision {
+		return res, false, nil
+	}
+	res, collision, err = NewDriverV2(d.L2).GetContext(ctx, hash)
+	if err == nil && res.found && !collision {
+		l1.SetContext(ctx, hash, res)
+	}
+	return res, collision, err
+}
+
+func (d DriverTiered) RemoveContext(ctx context.Context, hash string) error {
+	if d.writeBack != nil {
+		if err := d.writeBack.wait(ctx); err != nil {
+			return err
+		}
+	}
+	err := NewDriverV2(d.L1).RemoveContext(ctx, hash)
+	if err2 := NewDriverV2(d.L2).RemoveContext(ctx, hash); err == nil {
+		err = err2
+	}
+	return err
+}
+
+func (d DriverTiered) GetSizeContext(ctx context.Context) (int, error) {
+	return NewDriverV2(d.L2).GetSizeContext(ctx)
+}
+
+// RangeRequestOpts calls f for each request options entry in L2, which holds
+// every entry stored in L1, once pending writes have been applied. L2 must
+// implement IterableDriver.
+func (d DriverTiered) RangeRequestOpts(f func(hash string, req RequestOpts) bool) {
+	if l2, ok := d.L2.(IterableDriver); ok {
+		if d.writeBack != nil {
+			d.writeBack.wait(context.Background())
+		}
+		l2.RangeRequestOpts(f)
+	}
+}
+
+// Range calls f for each response object in L2 like RangeRequestOpts
+func (d DriverTiered) Range(f func(hash string, res Response) bool) {
+	if l2, ok := d.L2.(IterableDriver); ok {
+		if d.writeBack != nil {
+			d.writeBack.wait(context.Background())
+		}
+		l2.Range(f)
+	}
+}
+
+// Close stops the write-back worker. Writes which have not yet been applied to
+// L2 are discarded. The tiers are not closed.
+func (d DriverTiered) Close() error {
+	if d.writeBack != nil {
+		d.writeBack.close()
+	}
+	return nil
+}
+
+// GetTierSizes returns the number of response objects in each tier
+func (d DriverTiered) GetTierSizes() (l1, l2 int) {
+	return d.L1.GetSize(), d.L2.GetSize()
+}
+
+// OnEvict registers f with L2 if it implements EvictionNotifier. Response
+// objects evicted from L1 remain available in L2.
+func (d DriverTiered) OnEvict(f func(hash string)) {
+	if n, ok := d.L2.(EvictionNotifier); ok {
+		n.OnEvict(f)
+	}
+}
@@ -0,0 +1,156 @@
+package microcache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// DriverTiered should fall back to L2 and promote entries to L1
+func TestDriverTiered(t *testing.T) {
+	l1 := NewDriverLRU(2)
+	l2 := NewDriverLRU(10)
+	d := DriverTiered{L1: l1, L2: l2}
+	cache := New(Config{
+		TTL:     30 * time.Second,
+		Driver:  d,
+		Exposed: true,
+	})
+	defer cache.Stop()
+	handler := cache.Middleware(http.HandlerFunc(noopSuccessHandler))
+	var status = func(url string) string {
+		return getResponse(handler, url).Header().Get("microcache")
+	}
+
+	batchGet(handler, []string{"/a", "/b", "/c"})
+	if l1, l2 := d.GetTierSizes(); l1 != 2 || l2 != 3 || d.GetSize() != 3 {
+		t.Fatalf("DriverTiered should write through to both tiers, got %d %d", l1, l2)
+	}
+
+	// Evicted from L1
+	r, _ := http.NewRequest("GET", "/a", nil)
+	reqHash := getRequestHash(cache, r)
+	if req, _ := l1.GetRequestOpts(reqHash); req.found {
+		t.Fatal("Request options should be evicted from L1")
+	}
+	if status("/a") != "HIT" {
+		t.Fatal("DriverTiered should fall back to L2")
+	}
+	req, _ := l1.GetRequestOpts(reqHash)
+	objHash := req.getObjectHash(reqHash, r)
+	if res, _ := l1.Get(objHash); !req.found || !res.found {
+		t.Fatal("DriverTiered should promote entries to L1")
+	}
+
+	d.Remove(objHash)
+	if res, _ := l1.Get(objHash); res.found {
+		t.Fatal("DriverTiered should remove entries from L1")
+	}
+	if res, _ := l2.Get(objHash); res.found {
+		t.Fatal("DriverTiered should remove entries from L2")
+	}
+
+	// Write back
+	l2 = NewDriverLRU(10)
+	d = NewDriverTiered(NewDriverLRU(10), l2, true)
+	for i := 0; i < 100; i++ {
+		d.SetRequestOpts("a", RequestOpts{found: true, ttl: time.Duration(i)})
+	}
+	d.Set("a", Response{found: true})
+	for i := 0; i < 100 && l2.GetSize() == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if res, _ := l2.Get("a"); !res.found {
+		t.Fatal("DriverTiered should write back to L2")
+	}
+	if req, _ := l2.GetRequestOpts("a"); req.ttl != 99 {
+		t.Fatalf("DriverTiered should write back in order, got %d", req.ttl)
+	}
+	d.Set("b", Response{found: true})
+	d.Remove("b")
+	time.Sleep(10 * time.Millisecond)
+	if res, _ := l2.Get("b"); res.found {
+		t.Fatal("DriverTiered should not write back removed entries")
+	}
+	d.Close()
+}
+
+// DriverTiered should pass contexts and errors through to its tiers
+func TestDriverTieredContext(t *testing.T) {
+	errL2 := errors.New("l2")
+	l1 := NewDriverLRU(10)
+	d := DriverTiered{L1: l1, L2: &flakyDriver{DriverLRU: NewDriverLRU(10), err: errL2}}
+	if err := d.SetContext(context.Background(), "a", Response{found: true}); err != errL2 {
+		t.Fatal("DriverTiered should return errors writing to L2")
+	}
+	if _, _, err := d.GetContext(context.Background(), "b"); err != errL2 {
+		t.Fatal("DriverTiered should return errors reading from L2")
+	}
+	if res, _, err := d.GetContext(context.Background(), "a"); err != nil || !res.found {
+		t.Fatal("DriverTiered should not read L2 on L1 hits")
+	}
+	if err := d.RemoveContext(context.Background(), "a"); err != errL2 {
+		t.Fatal("DriverTiered should return errors removing from L2")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := d.GetSizeContext(ctx); err != context.Canceled {
+		t.Fatal("DriverTiered should pass contexts through")
+	}
+}
+
+// DriverTiered write-back should give up when the queue stays full
+func TestDriverTieredWriteBackContext(t *testing.T) {
+	d := DriverTiered{
+		L1:        NewDriverLRU(10),
+		L2:        &flakyDriver{DriverLRU: NewDriverLRU(10), delay: 100 * time.Millisecond},
+		writeBack: newTieredWriter(1),
+	}
+	d.Set("a", Response{found: true})
+	d.Set("b", Response{found: true})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := d.SetContext(ctx, "c", Response{found: true}); err != context.DeadlineExceeded {
+		t.Fatalf("Write-back should be bounded by the context, got %v", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := d.RemoveContext(ctx, "a"); err != context.DeadlineExceeded {
+		t.Fatalf("Remove should be bounded by the context, got %v", err)
+	}
+	d.Close()
+	if err := d.Set("d", Response{found: true}); err != ErrClosed {
+		t.Fatal("Write-back should stop when the driver is closed")
+	}
+}
+
+// DriverTiered should be iterable when L2 is
+func TestDriverTieredIterable(t *testing.T) {
+	for _, writeBack := range []bool{false, true} {
+		d := NewDriverTiered(NewDriverLRU(10), NewDriverLRU(10), writeBack)
+		cache := New(Config{
+			TTL:     30 * time.Second,
+			Driver:  d,
+			Exposed: true,
+		})
+		handler := cache.Middleware(http.HandlerFunc(noopSuccessHandler))
+		batchGet(handler, []string{"/a/1", "/a/2", "/b"})
+		if n, err := cache.PurgePrefix("/a"); n != 2 || err != nil {
+			t.Fatalf("DriverTiered should be iterable, got %d %v", n, err)
+		}
+		if getResponse(handler, "/a/1").Header().Get("microcache") != "MISS" ||
+			getResponse(handler, "/b").Header().Get("microcache") != "HIT" {
+			t.Fatal("DriverTiered should purge matching responses")
+		}
+		cache.Stop()
+		d.Close()
+	}
+
+	cache := New(Config{Driver: DriverTiered{L1: NewDriverLRU(10), L2: struct{ Driver }{NewDriverLRU(10)}}})
+	defer cache.Stop()
+	if _, err := cache.PurgePrefix("/a"); err != ErrNotIterable {
+		t.Fatal("DriverTiered should not be iterable when L2 is not")
+	}
+}
@@ -0,0 +1,307 @@
+package microcache
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Response and RequestOpts implement encoding.BinaryMarshaler and
+// encoding.BinaryUnmarshaler so that drivers outside of this package can
+// persist them.
+//
+// Wire format
+//
+//	magic    2 bytes  "mc"
+//	version  1 byte   encodingVersion
+//	type     1 byte   1 = Response, 2 = RequestOpts
+//	fields   repeated until the end of the data
+//	  tag    uvarint
+//	  length uvarint
+//	  value  length bytes
+//
+// Values are encoded by field type
+//
+//	bool            1 byte, 0 or 1
+//	int, duration   varint (zigzag)
+//	time            varint unix nanoseconds, omitted if zero
+//	string, []byte  raw bytes
+//	[]string        one field per element, in order
+//	http.Header     one field per value: uvarint name length, name, value
+//
+// Response fields
+//
+//	1 found  2 date  3 expires  4 status  5 headerWritten  6 header  7 body
+//	8 hash  9 delta  10 stale  11 reqHash
+//
+// RequestOpts fields
+//
+//	1 found  2 ttl  3 staleIfError  4 staleRecache  5 staleWhileRevalidate
+//	6 collapsedForwarding  7 vary  8 varyQuery  9 nocache  10 revalidate
+//	11 negativeTTL  12 path  13 purged  14 softPurged  15 hash  16 negative
+//
+// New fields are added with new tags without changing the version. Decoders
+// skip fields with unknown tags so older versions of this package can read
+// entries written by newer versions. The version is only incremented for
+// incompatible changes, which decoders reject with ErrUnsupportedVersion.
+const encodingVersion = 1
+
+const (
+	encodingTypeResponse    = 1
+	encodingTypeRequestOpts = 2
+)
+
+var (
+	// ErrInvalidEncoding is returned when unmarshaling malformed data
+	ErrInvalidEncoding = errors.New("microcache: invalid encoding")
+
+	// ErrUnsupportedVersion is returned when unmarshaling data written by an
+	// incompatible version of the wire format
+	ErrUnsupportedVersion = errors.New("microcache: unsupported encoding version")
+)
+
+// MarshalBinary encodes a response object
+func (res Response) MarshalBinary() ([]byte, error) {
+	e := newEncoder(encodingTypeResponse, len(res.body)+256)
+	e.bool(1, res.found)
+	e.time(2, res.date)
+	e.time(3, res.expires)
+	e.int(4, int64(res.status))
+	e.bool(5, res.headerWritten)
+	e.header(6, res.header)
+	e.bytes(7, res.body)
+	e.string(8, res.hash)
+	e.int(9, int64(res.delta))
+	e.int(10, int64(res.stale))
+	e.string(11, res.reqHash)
+	return e.buf, nil
+}
+
+// UnmarshalBinary decodes a response object encoded by MarshalBinary
+func (res *Response) UnmarshalBinary(data []byte) error {
+	*res = Response{header: http.Header{}}
+	return decode(data, encodingTypeResponse, func(tag uint64, v []byte) (err error) {
+		var i int64
+		switch tag {
+		case 1:
+			res.found, err = decodeBool(v)
+		case 2:
+			res.date, err = decodeTime(v)
+		case 3:
+			res.expires, err = decodeTime(v)
+		case 4:
+			i, err = decodeInt(v)
+			res.status = int(i)
+		case 5:
+			res.headerWritten, err = decodeBool(v)
+		case 6:
+			err = decodeHeader(v, res.header)
+		case 7:
+			res.body = append([]byte(nil), v...)
+		case 8:
+			res.hash = string(v)
+		case 9:
+			i, err = decodeInt(v)
+			res.delta = time.Duration(i)
+		case 10:
+			i, err = decodeInt(v)
+			res.stale = time.Duration(i)
+		case 11:
+			res.reqHash = string(v)
+		}
+		return err
+	})
+}
+
+// MarshalBinary encodes request options
+func (req RequestOpts) MarshalBinary() ([]byte, error) {
+	e := newEncoder(encodingTypeRequestOpts, 256)
+	e.bool(1, req.found)
+	e.int(2, int64(req.ttl))
+	e.int(3, int64(req.staleIfError))
+	e.bool(4, req.staleRecache)
+	e.int(5, int64(req.staleWhileRevalidate))
+	e.bool(6, req.collapsedForwarding)
+	for _, v := range req.vary {
+		e.string(7, v)
+	}
+	for _, v := range req.varyQuery {
+		e.string(8, v)
+	}
+	e.bool(9, req.nocache)
+	e.bool(10, req.revalidate)
+	e.int(11, int64(req.negativeTTL))
+	e.string(12, req.path)
+	e.time(13, req.purged)
+	e.time(14, req.softPurged)
+	e.string(15, req.hash)
+	e.bool(16, req.negative)
+	return e.buf, nil
+}
+
+// UnmarshalBinary decodes request options encoded by MarshalBinary
+func (req *RequestOpts) UnmarshalBinary(data []byte) error {
+	*req = RequestOpts{}
+	return decode(data, encodingTypeRequestOpts, func(tag uint64, v []byte) (err error) {
+		var i int64
+		switch tag {
+		case 1:
+			req.found, err = decodeBool(v)
+		case 2:
+			i, err = decodeInt(v)
+			req.ttl = time.Duration(i)
+		case 3:
+			i, err = decodeInt(v)
+			req.staleIfError = time.Duration(i)
+		case 4:
+			req.staleRecache, err = decodeBool(v)
+		case 5:
+			i, err = decodeInt(v)
+			req.staleWhileRevalidate = time.Duration(i)
+		case 6:
+			req.collapsedForwarding, err = decodeBool(v)
+		case 7:
+			req.vary = append(req.vary, string(v))
+		case 8:
+			req.varyQuery = append(req.varyQuery, string(v))
+		case 9:
+			req.nocache, err = decodeBool(v)
+		case 10:
+			req.revalidate, err = decodeBool(v)
+		case 11:
+			i, err = decodeInt(v)
+			req.negativeTTL = time.Duration(i)
+		case 12:
+			req.path = string(v)
+		case 13:
+			req.purged, err = decodeTime(v)
+		case 14:
+			req.softPurged, err = decodeTime(v)
+		case 15:
+			req.hash = string(v)
+		case 16:
+			req.negative, err = decodeBool(v)
+		}
+		return err
+	})
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func newEncoder(typ byte, size int) *encoder {
+	buf := make([]byte, 0, size)
+	return &encoder{append(buf, 'm', 'c', encodingVersion, typ)}
+}
+
+func (e *encoder) bytes(tag uint64, v []byte) {
+	e.buf = appendUvarint(e.buf, tag)
+	e.buf = appendUvarint(e.buf, uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) string(tag uint64, v string) {
+	e.bytes(tag, []byte(v))
+}
+
+func (e *encoder) bool(tag uint64, v bool) {
+	if v {
+		e.bytes(tag, []byte{1})
+	} else {
+		e.bytes(tag, []byte{0})
+	}
+}
+
+func (e *encoder) int(tag uint64, v int64) {
+	e.bytes(tag, appendVarint(nil, v))
+}
+
+func (e *encoder) time(tag uint64, v time.Time) {
+	if !v.IsZero() {
+		e.int(tag, v.UnixNano())
+	}
+}
+
+func (e *encoder) header(tag uint64, h http.Header) {
+	for name, values := range h {
+		for _, value := range values {
+			v := appendUvarint(nil, uint64(len(name)))
+			v = append(v, name...)
+			v = append(v, value...)
+			e.bytes(tag, v)
+		}
+	}
+}
+
+// decode validates the preamble of data and calls f for each field
+func decode(data []byte, typ byte, f func(tag uint64, v []byte) error) error {
+	if len(data) < 4 || data[0] != 'm' || data[1] != 'c' || data[3] != typ {
+		return ErrInvalidEncoding
+	}
+	if data[2] != encodingVersion {
+		return ErrUnsupportedVersion
+	}
+	data = data[4:]
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return ErrInvalidEncoding
+		}
+		data = data[n:]
+		length, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < length {
+			return ErrInvalidEncoding
+		}
+		v := data[n : n+int(length)]
+		data = data[n+int(length):]
+		if err := f(tag, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeBool(v []byte) (bool, error) {
+	if len(v) != 1 {
+		return false, ErrInvalidEncoding
+	}
+	return v[0] == 1, nil
+}
+
+func decodeInt(v []byte) (int64, error) {
+	i, n := binary.Varint(v)
+	if n <= 0 || n != len(v) {
+		return 0, ErrInvalidEncoding
+	}
+	return i, nil
+}
+
+func decodeTime(v []byte) (time.Time, error) {
+	i, err := decodeInt(v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, i), nil
+}
+
+func decodeHeader(v []byte, h http.Header) error {
+	length, n := binary.Uvarint(v)
+	if n <= 0 || uint64(len(v)-n) < length {
+		return ErrInvalidEncoding
+	}
+	name := string(v[n : n+int(length)])
+	h[name] = append(h[name], string(v[n+int(length):]))
+	return nil
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutUvarint(b[:], v)]...)
+}
+
+func appendVarint(buf []byte, v int64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutVarint(b[:], v)]...)
+}
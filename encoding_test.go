@@ -0,0 +1,89 @@
+package microcache
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Response and RequestOpts should survive a round trip through the wire format
+func TestEncoding(t *testing.T) {
+	res := Response{
+		found:         true,
+		date:          time.Unix(0, 1600000000123456789),
+		expires:       time.Unix(1600000030, 0),
+		status:        404,
+		headerWritten: true,
+		header:        http.Header{"Content-Type": []string{"text/plain"}, "Set-Cookie": []string{"a=1", "b=2"}},
+		body:          []byte("not found"),
+		hash:          "objhash",
+		delta:         15 * time.Millisecond,
+		stale:         time.Minute,
+		reqHash:       "req",
+	}
+	req := RequestOpts{
+		found:                true,
+		ttl:                  30 * time.Second,
+		staleIfError:         time.Minute,
+		staleRecache:         true,
+		staleWhileRevalidate: 20 * time.Second,
+		collapsedForwarding:  true,
+		vary:                 []string{"Accept-Language", "Accept-Encoding"},
+		varyQuery:            []string{"page"},
+		nocache:              true,
+		revalidate:           true,
+		negativeTTL:          -5 * time.Second,
+		path:                 "/a/b",
+		softPurged:           time.Unix(1600000010, 0),
+		negative:             true,
+		hash:                 "reqhash",
+	}
+
+	b, err := res.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res2 Response
+	if err := res2.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res, res2) {
+		t.Fatalf("Response round trip failed\n%#v\n%#v", res, res2)
+	}
+
+	b, err = req.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req2 RequestOpts
+	if err := req2.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(req, req2) {
+		t.Fatalf("RequestOpts round trip failed\n%#v\n%#v", req, req2)
+	}
+
+	// Unknown fields from newer versions are skipped
+	e := &encoder{b}
+	e.string(99, "future")
+	if err := req2.UnmarshalBinary(e.buf); err != nil || !reflect.DeepEqual(req, req2) {
+		t.Fatal("Unknown fields should be skipped", err)
+	}
+
+	// Malformed data
+	for name, tc := range map[string]struct {
+		data []byte
+		err  error
+	}{
+		"empty":     {nil, ErrInvalidEncoding},
+		"truncated": {b[:len(b)-1], ErrInvalidEncoding},
+		"type":      {append([]byte("mc\x01\x01"), b[4:]...), ErrInvalidEncoding},
+		"version":   {append([]byte("mc\x02\x02"), b[4:]...), ErrUnsupportedVersion},
+		"bool":      {[]byte("mc\x01\x02\x01\x02\x01\x01"), ErrInvalidEncoding},
+	} {
+		if err := req2.UnmarshalBinary(tc.data); err != tc.err {
+			t.Fatalf("%s: expected %v, got %v", name, tc.err, err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package microcache
+
+import (
+	"net/http"
+	"time"
+)
+
+// Entry describes the cache entry which would be used to answer a request
+type Entry struct {
+	// Found is true if a response object is cached for the request
+	Found bool
+
+	// Fresh is true if the response object would be served as a HIT
+	Fresh bool
+
+	// Stale is true if the response object has expired but may still be
+	// served by StaleWhileRevalidate or StaleIfError
+	Stale bool
+
+	Expires time.Time
+	Age     time.Duration
+	Status  int
+
+	// Size is the length of the response body in bytes
+	Size int
+
+	// Vary and VaryQuery are the request headers and query parameters
+	// which splinter the request
+	Vary      []string
+	VaryQuery []string
+
+	TTL                  time.Duration
+	StaleIfError         time.Duration
+	StaleWhileRevalidate time.Duration
+	Nocache              bool
+}
+
+// Lookup returns the cache entry which would be used to answer a request
+// without affecting the cache. Request options are reported even if no
+// response object is cached for the request's variant.
+func (m *microcache) Lookup(r *http.Request) (e Entry) {
+	reqHash := getRequestHash(m, r)
+	req, _, _ := m.getRequestOpts(r.Context(), reqHash)
+	if !req.found {
+		return e
+	}
+	e.Vary = req.vary
+	e.VaryQuery = req.varyQuery
+	e.TTL = req.ttl
+	e.StaleIfError = req.staleIfError
+	e.StaleWhileRevalidate = req.staleWhileRevalidate
+	e.Nocache = req.nocache
+	obj, _ := m.getObject(r.Context(), req.getObjectHash(reqHash, r))
+	if obj.found {
+		obj = m.applyPurges(req, obj)
+	}
+	if !obj.found {
+		return e
+	}
+	now := m.now()
+	e.Found = true
+	e.Fresh = obj.expires.After(now)
+	e.Stale = !e.Fresh && (obj.expires.Add(req.staleWhileRevalidate).After(now) ||
+		obj.expires.Add(req.staleIfError).After(now))
+	e.Expires = obj.expires
+	e.Age = now.Sub(obj.date)
+	e.Status = obj.status
+	if m.Compressor != nil {
+		obj = m.Compressor.Expand(obj)
+	}
+	e.Size = len(obj.body)
+	return e
+}
@@ -0,0 +1,48 @@
+package microcache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Lookup should report the cache entry for a request
+func TestLookup(t *testing.T) {
+	cache := New(Config{
+		TTL:                  30 * time.Second,
+		StaleWhileRevalidate: 10 * time.Second,
+		Vary:                 []string{"Accept-Language"},
+		Driver:               NewDriverLRU(10),
+		Compressor:           CompressorSnappy{},
+	})
+	defer cache.Stop()
+	handler := cache.Middleware(http.HandlerFunc(noopSuccessHandler))
+	r, _ := http.NewRequest("GET", "/a", nil)
+	if e := cache.Lookup(r); e.Found || e.TTL != 0 {
+		t.Fatalf("Lookup should not find uncached request %#v", e)
+	}
+	batchGet(handler, []string{"/a"})
+
+	cache.offsetIncr(20 * time.Second)
+	e := cache.Lookup(r)
+	if !e.Found || !e.Fresh || e.Stale || e.Status != 200 || e.Size != 5 ||
+		e.TTL != 30*time.Second || e.StaleWhileRevalidate != 10*time.Second ||
+		e.Age < 20*time.Second || len(e.Vary) != 1 || e.Vary[0] != "Accept-Language" {
+		t.Fatalf("Lookup should find fresh entry %#v", e)
+	}
+
+	cache.offsetIncr(15 * time.Second)
+	if e = cache.Lookup(r); !e.Found || e.Fresh || !e.Stale {
+		t.Fatalf("Lookup should find stale entry %#v", e)
+	}
+
+	cache.offsetIncr(10 * time.Second)
+	if e = cache.Lookup(r); !e.Found || e.Fresh || e.Stale {
+		t.Fatalf("Lookup should find expired entry %#v", e)
+	}
+
+	r.Header.Set("Accept-Language", "fr")
+	if e = cache.Lookup(r); e.Found {
+		t.Fatalf("Lookup should not find other variant %#v", e)
+	}
+}
@@ -2,7 +2,11 @@
 package microcache
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
+	"math/rand"
 	"net/http"
 	"strings"
 	"sync"
@@ -13,31 +17,63 @@ type Microcache interface {
 	Middleware(http.Handler) http.Handler
 	Start()
 	Stop()
+	Purge(string) (int, error)
+	PurgePrefix(string) (int, error)
+	PurgeAll()
+	PurgeTag(string) int
+	SoftPurge(string) (int, error)
+	SoftPurgePrefix(string) (int, error)
+	SoftPurgeAll()
+	SoftPurgeTag(string) int
+	AdminHandler() http.Handler
+	Lookup(*http.Request) Entry
 	offsetIncr(time.Duration)
 }
 
 type microcache struct {
-	Nocache              bool
-	Timeout              time.Duration
-	TTL                  time.Duration
-	StaleIfError         time.Duration
-	StaleRecache         bool
-	StaleWhileRevalidate time.Duration
-	HashQuery            bool
-	QueryIgnore          map[string]bool
-	CollapsedForwarding  bool
-	Vary                 []string
-	Driver               Driver
-	Compressor           Compressor
-	Monitor              Monitor
-	Exposed              bool
-	SuppressAgeHeader    bool
+	Nocache                  bool
+	Timeout                  time.Duration
+	TTL                      time.Duration
+	StaleIfError             time.Duration
+	StaleRecache             bool
+	StaleWhileRevalidate     time.Duration
+	HashQuery                bool
+	QueryIgnore              map[string]bool
+	CollapsedForwarding      bool
+	Vary                     []string
+	Driver                   Driver
+	DriverTimeout            time.Duration
+	Compressor               Compressor
+	Monitor                  Monitor
+	Exposed                  bool
+	SuppressAgeHeader        bool
+	Revalidate               bool
+	ResponseCacheControl     bool
+	RequestCacheControl      bool
+	RequestCacheControlAllow func(*http.Request) bool
+	MaxBodySize              int
+	MaxBodySizeNocache       bool
+	StreamMisses             bool
+	NegativeTTL              time.Duration
+	NegativeStatuses         map[int]bool
+	PurgeMethod              string
+	BanMethod                string
+	PurgeAuthorizer          func(*http.Request) bool
+	SoftInvalidation         bool
+	RefreshAhead             float64
+	RefreshAheadBeta         float64
 
 	stopMonitor     chan bool
 	revalidating    map[string]bool
 	revalidateMutex *sync.Mutex
 	collapse        map[string]*sync.Mutex
 	collapseMutex   *sync.Mutex
+	purgedAll       time.Time
+	softPurgedAll   time.Time
+	purgeMutex      *sync.RWMutex
+	tags            *tagIndex
+	pruneTags       bool
+	driver          DriverV2
 
 	// Used to advance time for testing
 	offset      time.Duration
@@ -112,6 +148,12 @@ type Config struct {
 	// Default: lru with 10,000 item capacity
 	Driver Driver
 
+	// DriverTimeout bounds each driver call made by the middleware, purges and
+	// lookups. Drivers implementing DriverV2 abandon calls which exceed it and
+	// the request is treated as a cache miss.
+	// Default: 0 (calls are only bounded by the request context)
+	DriverTimeout time.Duration
+
 	// Compressor specifies a compressor to use for reducing the memory required to cache
 	// response bodies
 	// Default: nil
@@ -132,35 +174,189 @@ type Config struct {
 	// Age: ( seconds )
 	// Default: false
 	SuppressAgeHeader bool
+
+	// Revalidate specifies whether expired responses should be revalidated by sending
+	// the stored validators (If-None-Match / If-Modified-Since) to the backend.
+	// If the backend answers 304 Not Modified, the stored response is updated
+	// with the header fields of the 304 and refreshed for ttl instead of being
+	// replaced. With ResponseCacheControl the ttl is derived from the updated
+	// header fields. Expired responses are kept for one ttl after expiration so
+	// that drivers which expire entries natively retain them.
+	// Can be overridden by the microcache-revalidate and microcache-no-revalidate
+	// response headers
+	// Default: false
+	Revalidate bool
+
+	// ResponseCacheControl specifies whether request options should be derived from
+	// the standard Cache-Control and Expires response headers when a response contains
+	// no microcache- headers other than microcache-tags. Supported directives are max-age, s-maxage, no-store,
+	// no-cache and private as well as stale-while-revalidate and stale-if-error.
+	// More Info: https://tools.ietf.org/html/rfc9111 https://tools.ietf.org/html/rfc5861
+	// Default: false
+	ResponseCacheControl bool
+
+	// RequestCacheControl specifies whether to honor client Cache-Control request
+	// directives. no-cache and max-age=0 force a backend request which updates the cache,
+	// max-age and min-fresh restrict which cached responses are considered fresh,
+	// max-stale allows stale responses to be served beyond the stale-while-revalidate
+	// period and only-if-cached returns 504 when no cached response is available.
+	// Drivers which expire entries natively keep them for one ttl after
+	// expiration, which bounds max-stale.
+	// More Info: https://tools.ietf.org/html/rfc9111#section-5.2.1
+	// Default: false
+	RequestCacheControl bool
+
+	// RequestCacheControlAllow determines which requests may apply their
+	// Cache-Control directives when RequestCacheControl is enabled
+	//
+	//   func(r *http.Request) bool { return r.Header.Get("x-internal") != "" }
+	//
+	// Default: nil (all requests)
+	RequestCacheControlAllow func(*http.Request) bool
+
+	// MaxBodySize specifies the maximum number of bytes of a backend response body
+	// to capture for caching. Once a response body exceeds this size, the response
+	// is streamed to the client and not cached. Note that http.TimeoutHandler
+	// (see Timeout) buffers complete responses regardless of this setting.
+	// Default: 0 (unlimited)
+	MaxBodySize int
+
+	// MaxBodySizeNocache specifies whether responses exceeding MaxBodySize should mark
+	// their request as uncacheable, passing subsequent requests straight through
+	// to the backend as if the response had included the microcache-nocache header
+	// Default: false
+	MaxBodySizeNocache bool
+
+	// StreamMisses specifies whether backend responses should be sent to the client
+	// as they are produced rather than after the handler returns. Responses are still
+	// captured for the cache unless the handler fails partway. Server errors are
+	// buffered as usual so that stale responses can be served in their place.
+	// Note that http.TimeoutHandler (see Timeout) buffers complete responses
+	// regardless of this setting.
+	// Headers are sent before the body is known, so a streamed miss carries no
+	// generated Etag unless the handler sets one. Subsequent hits do. Handlers
+	// serving clients which poll with conditional requests should set their own Etag.
+	// Default: false
+	StreamMisses bool
+
+	// NegativeTTL specifies a ttl for caching error responses with a status listed
+	// in NegativeStatuses. This protects the backend from floods of requests for
+	// missing resources. Hits on negatively cached responses are reported separately
+	// as Negatives to monitors implementing NegativeMonitor.
+	// Can be overridden by the microcache-negative-ttl response header
+	// Recommended: 5s
+	// Default: 0
+	NegativeTTL time.Duration
+
+	// NegativeStatuses specifies the response statuses eligible for negative caching
+	// Default: []int{404, 405, 410}
+	NegativeStatuses []int
+
+	// PurgeMethod specifies an HTTP request method which invalidates all cached
+	// responses for the requested URL without reaching the wrapped handler.
+	// Responds 200 if the URL was cached, 404 if not and 403 if not authorized.
+	//
+	//   curl -X PURGE https://host/path
+	//
+	// Default: "" (disabled)
+	PurgeMethod string
+
+	// BanMethod specifies an HTTP request method which invalidates all cached
+	// responses for URL paths matching the regular expression in the X-Ban-Url
+	// request header without reaching the wrapped handler. Requires a driver
+	// implementing IterableDriver.
+	//
+	//   curl -X BAN -H "X-Ban-Url: ^/products/" https://host/
+	//
+	// Default: "" (disabled)
+	BanMethod string
+
+	// PurgeAuthorizer determines whether a PurgeMethod or BanMethod request is allowed
+	// Default: nil (all purge requests are forbidden)
+	PurgeAuthorizer func(*http.Request) bool
+
+	// SoftInvalidation marks cached responses stale instead of removing them when they
+	// are invalidated by unsafe requests or by PurgeMethod and BanMethod requests.
+	// The next request revalidates the response while the stale copy remains
+	// available to StaleIfError and StaleWhileRevalidate.
+	// Default: false
+	SoftInvalidation bool
+
+	// RefreshAhead specifies a fraction of the TTL before expiration during which
+	// a request for a fresh response triggers revalidation in the background.
+	// Popular responses are refreshed before they go stale.
+	// e.g. 0.2 refreshes a response with a TTL of 10s when requested after 8s
+	// Negatively cached responses (see NegativeTTL) are never refreshed ahead.
+	// Default: 0 (disabled)
+	RefreshAhead float64
+
+	// RefreshAheadBeta enables probabilistic early revalidation (XFetch).
+	// Each request for a fresh response triggers revalidation in the background
+	// with a probability which increases as expiration approaches and with the time
+	// the backend took to render the response. Values above 1 favor earlier
+	// revalidation.
+	// More Info: https://cseweb.ucsd.edu/~avattani/papers/cache_stampede.pdf
+	// Recommended: 1
+	// Default: 0 (disabled)
+	RefreshAheadBeta float64
 }
 
 // New creates and returns a configured microcache instance
 func New(o Config) *microcache {
 	// Defaults
 	m := microcache{
-		Nocache:              o.Nocache,
-		TTL:                  o.TTL,
-		StaleIfError:         o.StaleIfError,
-		StaleRecache:         o.StaleRecache,
-		StaleWhileRevalidate: o.StaleWhileRevalidate,
-		Timeout:              o.Timeout,
-		HashQuery:            o.HashQuery,
-		CollapsedForwarding:  o.CollapsedForwarding,
-		Vary:                 o.Vary,
-		Driver:               o.Driver,
-		Compressor:           o.Compressor,
-		Monitor:              o.Monitor,
-		Exposed:              o.Exposed,
-		SuppressAgeHeader:    o.SuppressAgeHeader,
-		revalidating:         map[string]bool{},
-		revalidateMutex:      &sync.Mutex{},
-		collapse:             map[string]*sync.Mutex{},
-		collapseMutex:        &sync.Mutex{},
-		offsetMutex:          &sync.RWMutex{},
+		Nocache:                  o.Nocache,
+		TTL:                      o.TTL,
+		StaleIfError:             o.StaleIfError,
+		StaleRecache:             o.StaleRecache,
+		StaleWhileRevalidate:     o.StaleWhileRevalidate,
+		Timeout:                  o.Timeout,
+		HashQuery:                o.HashQuery,
+		CollapsedForwarding:      o.CollapsedForwarding,
+		Vary:                     o.Vary,
+		Driver:                   o.Driver,
+		DriverTimeout:            o.DriverTimeout,
+		Compressor:               o.Compressor,
+		Monitor:                  o.Monitor,
+		Exposed:                  o.Exposed,
+		SuppressAgeHeader:        o.SuppressAgeHeader,
+		Revalidate:               o.Revalidate,
+		ResponseCacheControl:     o.ResponseCacheControl,
+		RequestCacheControl:      o.RequestCacheControl,
+		RequestCacheControlAllow: o.RequestCacheControlAllow,
+		MaxBodySize:              o.MaxBodySize,
+		MaxBodySizeNocache:       o.MaxBodySizeNocache,
+		StreamMisses:             o.StreamMisses,
+		NegativeTTL:              o.NegativeTTL,
+		NegativeStatuses:         map[int]bool{404: true, 405: true, 410: true},
+		PurgeMethod:              o.PurgeMethod,
+		BanMethod:                o.BanMethod,
+		PurgeAuthorizer:          o.PurgeAuthorizer,
+		SoftInvalidation:         o.SoftInvalidation,
+		RefreshAhead:             o.RefreshAhead,
+		RefreshAheadBeta:         o.RefreshAheadBeta,
+		revalidating:             map[string]bool{},
+		revalidateMutex:          &sync.Mutex{},
+		collapse:                 map[string]*sync.Mutex{},
+		collapseMutex:            &sync.Mutex{},
+		purgeMutex:               &sync.RWMutex{},
+		tags:                     newTagIndex(),
+		offsetMutex:              &sync.RWMutex{},
 	}
 	if o.Driver == nil {
 		m.Driver = NewDriverLRU(1e4) // default 10k cache items
 	}
+	m.driver = NewDriverV2(m.Driver)
+	if d, ok := m.Driver.(EvictionNotifier); ok {
+		d.OnEvict(m.tags.evict)
+	}
+	m.pruneTags = !notifiesEvictions(m.Driver)
+	if o.NegativeStatuses != nil {
+		m.NegativeStatuses = make(map[int]bool)
+		for _, status := range o.NegativeStatuses {
+			m.NegativeStatuses[status] = true
+		}
+	}
 	if o.QueryIgnore != nil {
 		m.QueryIgnore = make(map[string]bool)
 		for _, key := range o.QueryIgnore {
@@ -196,9 +392,22 @@ func (m *microcache) Middleware(h http.Handler) http.Handler {
 			return
 		}
 
+		// Purge and ban requests
+		if (m.PurgeMethod != "" && r.Method == m.PurgeMethod) ||
+			(m.BanMethod != "" && r.Method == m.BanMethod) {
+			m.servePurge(w, r)
+			return
+		}
+
+		// Client cache directives
+		var rcc requestCacheControl
+		if m.RequestCacheControl && (m.RequestCacheControlAllow == nil || m.RequestCacheControlAllow(r)) {
+			rcc = parseRequestCacheControl(r)
+		}
+
 		// Fetch request options
 		reqHash := getRequestHash(m, r)
-		req, collision := m.Driver.GetRequestOpts(reqHash)
+		req, collision, err := m.getRequestOpts(r.Context(), reqHash)
 
 		if collision {
 			if m.Monitor != nil {
@@ -206,12 +415,14 @@ func (m *microcache) Middleware(h http.Handler) http.Handler {
 			}
 		}
 
-		// Hard passthrough on non cacheable requests
-		if req.nocache {
-			if m.Monitor != nil {
-				m.Monitor.Miss()
+		// Hard passthrough on non cacheable requests. Requests whose options
+		// could not be read are passed through as well so that options holding
+		// purge times are not replaced by rebuilt ones.
+		if req.nocache || err != nil {
+			if err != nil && m.Exposed {
+				w.Header().Set("microcache", "MISS")
 			}
-			h.ServeHTTP(w, r)
+			m.passthrough(h, w, r, rcc)
 			return
 		}
 
@@ -235,12 +446,19 @@ func (m *microcache) Middleware(h http.Handler) http.Handler {
 				m.collapseMutex.Unlock()
 			}()
 			if !req.found {
-				req, collision = m.Driver.GetRequestOpts(reqHash)
+				req, collision, err = m.getRequestOpts(r.Context(), reqHash)
 				if collision {
 					if m.Monitor != nil {
 						m.Monitor.Collision()
 					}
 				}
+				if req.nocache || err != nil {
+					if err != nil && m.Exposed {
+						w.Header().Set("microcache", "MISS")
+					}
+					m.passthrough(h, w, r, rcc)
+					return
+				}
 			}
 		}
 
@@ -249,12 +467,16 @@ func (m *microcache) Middleware(h http.Handler) http.Handler {
 		var obj Response
 		if req.found {
 			objHash = req.getObjectHash(reqHash, r)
-			obj, collision = m.Driver.Get(objHash)
+			obj, collision = m.getObject(r.Context(), objHash)
 			if collision {
 				if m.Monitor != nil {
 					m.Monitor.Collision()
 				}
 			}
+			// Response predates invalidation of the request
+			if obj.found {
+				obj = m.applyPurges(req, obj)
+			}
 			if m.Compressor != nil {
 				obj = m.Compressor.Expand(obj)
 			}
@@ -265,35 +487,47 @@ func (m *microcache) Middleware(h http.Handler) http.Handler {
 			if m.Monitor != nil {
 				m.Monitor.Miss()
 			}
-			if obj.found {
-				// HTTP spec requires caches to purge cached responses following
-				// successful unsafe request
-				ptw := passthroughWriter{w, 0}
-				h.ServeHTTP(&ptw, r)
-				if ptw.status >= 200 && ptw.status < 400 {
-					m.Driver.Remove(objHash)
+			// HTTP spec requires caches to invalidate cached responses following
+			// successful unsafe request (RFC 9111 §4.4)
+			ptw := passthroughWriter{w, 0}
+			h.ServeHTTP(&ptw, r)
+			if ptw.status == 0 {
+				ptw.status = http.StatusOK
+			}
+			if ptw.status >= 200 && ptw.status < 400 {
+				if obj.found && !m.SoftInvalidation {
+					m.remove(objHash)
 				}
-			} else {
-				h.ServeHTTP(w, r)
+				m.invalidateRequest(r, reqHash, req, m.SoftInvalidation)
+				m.invalidateLocations(r, ptw.Header())
 			}
 			return
 		}
 
 		// Fresh response object found
-		if obj.found && obj.expires.After(m.now()) {
+		if obj.found && rcc.fresh(obj, m.now()) {
 			if m.Monitor != nil {
-				m.Monitor.Hit()
+				if nm, ok := m.Monitor.(NegativeMonitor); ok && obj.status >= 400 {
+					nm.Negative()
+				} else {
+					m.Monitor.Hit()
+				}
 			}
 			if m.Exposed {
 				w.Header().Set("microcache", "HIT")
 			}
 			m.setAgeHeader(w, obj)
-			obj.sendResponse(w)
+			obj.sendCachedResponse(w, r)
+
+			// Refresh Ahead
+			if m.refreshAhead(req, obj) {
+				m.revalidateInBackground(h, r, reqHash, req, objHash, obj)
+			}
 			return
 		}
 
 		// Stale While Revalidate
-		if obj.found && req.staleWhileRevalidate > 0 &&
+		if obj.found && req.staleWhileRevalidate > 0 && rcc.allowsStale() &&
 			obj.expires.Add(req.staleWhileRevalidate).After(m.now()) {
 			if m.Monitor != nil {
 				m.Monitor.Stale()
@@ -302,36 +536,87 @@ func (m *microcache) Middleware(h http.Handler) http.Handler {
 				w.Header().Set("microcache", "STALE")
 			}
 			m.setAgeHeader(w, obj)
-			obj.sendResponse(w)
+			obj.sendCachedResponse(w, r)
+			m.revalidateInBackground(h, r, reqHash, req, objHash, obj)
+			return
+		}
 
-			// Dedupe revalidation
-			m.revalidateMutex.Lock()
-			_, revalidating := m.revalidating[objHash]
-			if !revalidating {
-				m.revalidating[objHash] = true
+		// Stale response explicitly accepted by client
+		if obj.found && rcc.acceptsStale(obj, m.now()) {
+			if m.Monitor != nil {
+				m.Monitor.Stale()
 			}
-			m.revalidateMutex.Unlock()
-			if !revalidating {
-				br := newBackgroundRequest(r)
-				go func() {
-					defer func() {
-						// Clear revalidation lock
-						m.revalidateMutex.Lock()
-						delete(m.revalidating, objHash)
-						m.revalidateMutex.Unlock()
-					}()
-					m.handleBackendResponse(h, w, br, reqHash, req, objHash, obj, true)
-				}()
+			if m.Exposed {
+				w.Header().Set("microcache", "STALE")
 			}
-
+			m.setAgeHeader(w, obj)
+			obj.sendCachedResponse(w, r)
 			return
-		} else {
-			m.handleBackendResponse(h, w, r, reqHash, req, objHash, obj, false)
+		}
+
+		// Client refuses to wait for the backend
+		if rcc.onlyIfCached {
+			if m.Monitor != nil {
+				m.Monitor.Miss()
+			}
+			m.sendGatewayTimeout(w)
 			return
 		}
+
+		m.handleBackendResponse(h, w, r, reqHash, req, objHash, obj, false)
 	})
 }
 
+// revalidateInBackground fetches a response object from the backend without
+// blocking the request. Concurrent revalidations of an object are deduplicated.
+func (m *microcache) revalidateInBackground(
+	h http.Handler,
+	r *http.Request,
+	reqHash string,
+	req RequestOpts,
+	objHash string,
+	obj Response,
+) {
+	m.revalidateMutex.Lock()
+	_, revalidating := m.revalidating[objHash]
+	if !revalidating {
+		m.revalidating[objHash] = true
+	}
+	m.revalidateMutex.Unlock()
+	if revalidating {
+		return
+	}
+	br := newBackgroundRequest(r)
+	go func() {
+		defer func() {
+			// Clear revalidation lock
+			m.revalidateMutex.Lock()
+			delete(m.revalidating, objHash)
+			m.revalidateMutex.Unlock()
+		}()
+		m.handleBackendResponse(h, nil, br, reqHash, req, objHash, obj, true)
+	}()
+}
+
+// refreshAhead reports whether a fresh response object should be revalidated
+// in the background before it expires. Negatively cached responses are not
+// refreshed so that they keep shielding the backend until they expire.
+func (m *microcache) refreshAhead(req RequestOpts, obj Response) bool {
+	if obj.status >= 400 {
+		return false
+	}
+	ttl := obj.expires.Sub(m.now())
+	if m.RefreshAhead > 0 && ttl < time.Duration(float64(req.ttl)*m.RefreshAhead) {
+		return true
+	}
+	// XFetch (Vattani et al.) recomputes expensive responses earlier
+	if m.RefreshAheadBeta > 0 && obj.delta > 0 {
+		early := obj.delta.Seconds() * m.RefreshAheadBeta * -math.Log(1-rand.Float64())
+		return early >= ttl.Seconds()
+	}
+	return false
+}
+
 func (m *microcache) handleBackendResponse(
 	h http.Handler,
 	w http.ResponseWriter,
@@ -347,27 +632,90 @@ func (m *microcache) handleBackendResponse(
 	}
 
 	// Backend Response
-	beres := Response{header: http.Header{}}
+	beres := Response{header: http.Header{}, maxBodySize: m.MaxBodySize}
+	if !background {
+		beres.w = w
+		if m.Exposed {
+			w.Header().Set("microcache", "MISS")
+		}
+	}
+
+	// Client validators and byte ranges are answered by the cache so that the
+	// backend always renders a full response which can be stored. Stored
+	// validators are sent to the backend instead when revalidating.
+	ber := r
+	revalidating := obj.found && req.revalidate &&
+		(obj.header.Get("Etag") != "" || obj.header.Get("Last-Modified") != "")
+	if !background && !revalidating {
+		beres.tee = m.StreamMisses
+	}
+	if revalidating || r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" ||
+		r.Header.Get("Range") != "" {
+		ber = r.Clone(r.Context())
+		ber.Header.Del("If-None-Match")
+		ber.Header.Del("If-Modified-Since")
+		ber.Header.Del("Range")
+		ber.Header.Del("If-Range")
+	}
+	if revalidating {
+		if etag := obj.header.Get("Etag"); etag != "" {
+			ber.Header.Set("If-None-Match", etag)
+		}
+		if lm := obj.header.Get("Last-Modified"); lm != "" {
+			ber.Header.Set("If-Modified-Since", lm)
+		}
+	}
 
 	// Execute request
-	h.ServeHTTP(&beres, r)
+	start := time.Now()
+	h.ServeHTTP(&beres, ber)
+	beres.delta = time.Since(start)
 
 	if !beres.headerWritten {
 		beres.status = http.StatusOK
 	}
 
+	// Stored response is still valid, refresh it and reuse the stored body
+	if revalidating && beres.status == http.StatusNotModified {
+		obj = m.refreshObject(req, obj, beres.header)
+		m.store(reqHash, objHash, req, obj)
+		if background {
+			return
+		}
+		if m.Monitor != nil {
+			m.Monitor.Miss()
+		}
+		obj.sendCachedResponse(w, r)
+		return
+	}
+
 	// Log Error
 	if beres.status >= 500 && m.Monitor != nil {
 		m.Monitor.Error()
 	}
 
+	// Response body exceeded MaxBodySize and was streamed to the client
+	if beres.uncacheable {
+		if m.MaxBodySizeNocache {
+			if !req.found || req.negative {
+				req = m.rebuildRequestOpts(req, beres, r)
+			}
+			req.nocache = true
+			m.setRequestOpts(reqHash, req)
+		}
+		if !background && m.Monitor != nil {
+			m.Monitor.Miss()
+		}
+		return
+	}
+
 	// Serve Stale
 	if beres.status >= 500 && obj.found {
 		serveStale := obj.expires.Add(req.staleIfError).After(m.now())
 		// Extend stale response expiration by staleIfError grace period
 		if req.found && serveStale && req.staleRecache {
 			obj.expires = obj.date.Add(m.getOffset()).Add(req.ttl)
-			m.store(objHash, obj)
+			m.store(reqHash, objHash, req, obj)
 		}
 		if !background && serveStale {
 			if m.Monitor != nil {
@@ -377,23 +725,53 @@ func (m *microcache) handleBackendResponse(
 				w.Header().Set("microcache", "STALE")
 			}
 			m.setAgeHeader(w, obj)
-			obj.sendResponse(w)
+			obj.sendCachedResponse(w, r)
 			return
 		}
 	}
 
+	// Abandon streamed responses which failed partway
+	if beres.sent && (r.Context().Err() != nil || beres.writeErr != nil) {
+		if m.Monitor != nil {
+			m.Monitor.Miss()
+		}
+		return
+	}
+
 	// Backend Request succeeded
-	if beres.status >= 200 && beres.status < 400 {
-		if !req.found {
+	// HEAD responses have no body and must not be stored as the GET representation
+	if beres.status >= 200 && beres.status < 400 && r.Method != "HEAD" {
+		if !req.found || req.negative {
 			// Store request options
-			req = buildRequestOpts(m, beres, r)
-			m.Driver.SetRequestOpts(reqHash, req)
+			req = m.rebuildRequestOpts(req, beres, r)
+			m.setRequestOpts(reqHash, req)
 			objHash = req.getObjectHash(reqHash, r)
 		}
 		// Cache response
 		if !req.nocache {
+			if beres.header.Get("Etag") == "" {
+				beres.header.Set("Etag", generateEtag(beres.body))
+			}
 			beres.expires = m.now().Add(req.ttl)
-			m.store(objHash, beres)
+			m.store(reqHash, objHash, req, beres)
+		}
+	}
+
+	// Negative caching
+	if m.NegativeStatuses[beres.status] && r.Method != "HEAD" {
+		nreq := req
+		if !req.found {
+			nreq = buildRequestOpts(m, beres, r)
+			nreq.negative = true
+		}
+		if !nreq.nocache && nreq.negativeTTL > 0 {
+			if !req.found {
+				// Store request options
+				m.setRequestOpts(reqHash, nreq)
+				objHash = nreq.getObjectHash(reqHash, r)
+			}
+			beres.expires = m.now().Add(nreq.negativeTTL)
+			m.store(reqHash, objHash, nreq, beres)
 		}
 	}
 
@@ -405,10 +783,49 @@ func (m *microcache) handleBackendResponse(
 	if m.Monitor != nil {
 		m.Monitor.Miss()
 	}
-	if m.Exposed {
-		w.Header().Set("microcache", "MISS")
+	if !beres.sent {
+		if r.Method == "GET" {
+			beres.sendCachedResponse(w, r)
+		} else {
+			beres.sendResponse(w)
+		}
+	}
+}
+
+// refreshObject updates a stored response with the header fields of a 304 Not
+// Modified response (RFC 9111 §4.3.4) and extends its expiration. The ttl is
+// derived from the updated header fields if ResponseCacheControl applies.
+func (m *microcache) refreshObject(req RequestOpts, obj Response, header http.Header) Response {
+	obj.header = obj.header.Clone()
+	if obj.header == nil {
+		obj.header = http.Header{}
 	}
-	beres.sendResponse(w)
+	for name, values := range header {
+		if name != "Content-Length" {
+			obj.header[name] = values
+		}
+	}
+	ttl := req.ttl
+	if m.ResponseCacheControl && !hasMicrocacheHeader(obj.header) {
+		cc := req
+		cc.nocache = false
+		applyResponseCacheControl(&cc, obj.header)
+		ttl = cc.ttl
+		if cc.nocache {
+			ttl = 0
+		}
+	}
+	obj.expires = m.now().Add(ttl)
+	return obj
+}
+
+// rebuildRequestOpts builds request options from a backend response, keeping
+// the purge timestamps of the options they replace
+func (m *microcache) rebuildRequestOpts(old RequestOpts, res Response, r *http.Request) RequestOpts {
+	req := buildRequestOpts(m, res, r)
+	req.purged = old.purged
+	req.softPurged = old.softPurged
+	return req
 }
 
 // Start starts the monitor and any other required background processes
@@ -422,7 +839,7 @@ func (m *microcache) Start() {
 			select {
 			case <-time.After(m.Monitor.GetInterval()):
 				m.Monitor.Log(Stats{
-					Size: m.Driver.GetSize(),
+					Size: m.getSize(),
 				})
 			case <-m.stopMonitor:
 				return
@@ -439,15 +856,140 @@ func (m *microcache) setAgeHeader(w http.ResponseWriter, obj Response) {
 	}
 }
 
-// store sets the age header if not suppressed
-func (m *microcache) store(objHash string, obj Response) {
+// passthrough serves a request from the backend without caching it
+func (m *microcache) passthrough(h http.Handler, w http.ResponseWriter, r *http.Request, rcc requestCacheControl) {
+	if m.Monitor != nil {
+		m.Monitor.Miss()
+	}
+	if rcc.onlyIfCached {
+		m.sendGatewayTimeout(w)
+		return
+	}
+	h.ServeHTTP(w, r)
+}
+
+// sendGatewayTimeout answers only-if-cached requests which can not be served from cache
+func (m *microcache) sendGatewayTimeout(w http.ResponseWriter) {
+	if m.Exposed {
+		w.Header().Set("microcache", "MISS")
+	}
+	http.Error(w, "Gateway Timeout", http.StatusGatewayTimeout)
+}
+
+// store stores a response object
+func (m *microcache) store(reqHash, objHash string, req RequestOpts, obj Response) {
 	obj.found = true
+	obj.w = nil
 	obj.date = time.Now()
+	obj.reqHash = reqHash
+	obj.stale = req.staleIfError
+	if req.staleWhileRevalidate > obj.stale {
+		obj.stale = req.staleWhileRevalidate
+	}
+	// Drivers may drop response objects once their stale windows pass. Keep
+	// expired responses for another TTL while they can still be revalidated or
+	// requested by clients accepting stale responses.
+	if (req.revalidate || m.RequestCacheControl) && req.ttl > obj.stale {
+		obj.stale = req.ttl
+	}
+	tags := getResponseTags(obj)
 	if m.Compressor != nil {
-		m.Driver.Set(objHash, m.Compressor.Compress(obj))
-	} else {
-		m.Driver.Set(objHash, obj)
+		obj = m.Compressor.Compress(obj)
+	}
+	err := m.setObject(objHash, obj)
+	// Stop caching responses which are too large for the driver
+	if err == ErrEntryTooLarge && req.found {
+		req.nocache = true
+		m.setRequestOpts(reqHash, req)
+		return
+	}
+	if err != nil {
+		return
+	}
+	m.tags.set(objHash, tags)
+	if m.pruneTags && len(tags) > 0 && m.tags.startPrune() {
+		go m.pruneTagIndex()
+	}
+}
+
+// remove removes a response object from the cache
+func (m *microcache) remove(objHash string) error {
+	ctx, cancel := m.driverContext(context.Background())
+	defer cancel()
+	err := m.driver.RemoveContext(ctx, objHash)
+	m.driverError(err)
+	m.tags.evict(objHash)
+	return err
+}
+
+// driverContext bounds a driver call by DriverTimeout
+func (m *microcache) driverContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if m.DriverTimeout > 0 {
+		return context.WithTimeout(ctx, m.DriverTimeout)
 	}
+	return context.WithCancel(ctx)
+}
+
+// driverError reports a failed driver call to the monitor. Calls cancelled
+// because the client went away are not driver errors.
+func (m *microcache) driverError(err error) {
+	if err == nil || err == ErrEntryTooLarge || errors.Is(err, context.Canceled) {
+		return
+	}
+	if dm, ok := m.Monitor.(DriverErrorMonitor); ok {
+		dm.DriverError()
+	}
+}
+
+// getRequestOpts fetches request options. Failed reads return no options
+// along with the error, which callers must not answer by storing new options.
+func (m *microcache) getRequestOpts(ctx context.Context, reqHash string) (RequestOpts, bool, error) {
+	ctx, cancel := m.driverContext(ctx)
+	defer cancel()
+	req, collision, err := m.driver.GetRequestOptsContext(ctx, reqHash)
+	if err != nil {
+		m.driverError(err)
+		return RequestOpts{}, false, err
+	}
+	return req, collision, nil
+}
+
+// getObject fetches a response object, treating driver errors as misses
+func (m *microcache) getObject(ctx context.Context, objHash string) (Response, bool) {
+	ctx, cancel := m.driverContext(ctx)
+	defer cancel()
+	obj, collision, err := m.driver.GetContext(ctx, objHash)
+	if err != nil {
+		m.driverError(err)
+		return Response{}, false
+	}
+	return obj, collision
+}
+
+// setRequestOpts stores request options. Writes are not bound to the request
+// context so that they complete after the client goes away.
+func (m *microcache) setRequestOpts(reqHash string, req RequestOpts) {
+	ctx, cancel := m.driverContext(context.Background())
+	defer cancel()
+	m.driverError(m.driver.SetRequestOptsContext(ctx, reqHash, req))
+}
+
+// setObject stores a response object
+func (m *microcache) setObject(objHash string, obj Response) error {
+	ctx, cancel := m.driverContext(context.Background())
+	defer cancel()
+	err := m.driver.SetContext(ctx, objHash, obj)
+	m.driverError(err)
+	return err
+}
+
+// getSize returns the number of response objects in the cache
+func (m *microcache) getSize() int {
+	ctx, cancel := m.driverContext(context.Background())
+	defer cancel()
+	n, err := m.driver.GetSizeContext(ctx)
+	m.driverError(err)
+	return n
 }
 
 // Stop stops the monitor and any other required background processes
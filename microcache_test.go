@@ -684,6 +684,577 @@ func TestUnsafePurge(t *testing.T) {
 	}
 }
 
+// Conditional requests should be answered with 304 from the cache
+func TestConditionalRequest(t *testing.T) {
+	cache := New(Config{
+		TTL:                  30 * time.Second,
+		StaleWhileRevalidate: 30 * time.Second,
+		Driver:               NewDriverLRU(10),
+		Exposed:              true,
+	})
+	defer cache.Stop()
+	handler := cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/lm" {
+			w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+			w.Header().Set("Etag", `W/"lm"`)
+		}
+		w.Write([]byte("done"))
+	}))
+	etag := getResponse(handler, "/").Header().Get("Etag")
+	if etag == "" {
+		t.Fatal("Etag should have been generated")
+	}
+	batchGet(handler, []string{"/lm"})
+	cases := []struct {
+		url    string
+		hdr    map[string]string
+		status int
+	}{
+		{"/", map[string]string{}, 200},
+		{"/", map[string]string{"If-None-Match": etag}, 304},
+		{"/", map[string]string{"If-None-Match": `"other", ` + etag}, 304},
+		{"/", map[string]string{"If-None-Match": `"other"`}, 200},
+		{"/", map[string]string{"If-None-Match": `*`}, 304},
+		{"/lm", map[string]string{"If-None-Match": `"lm"`}, 304},
+		{"/lm", map[string]string{"If-Modified-Since": "Mon, 02 Jan 2006 15:04:05 GMT"}, 304},
+		{"/lm", map[string]string{"If-Modified-Since": "Mon, 02 Jan 2006 15:04:04 GMT"}, 200},
+		{"/lm", map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": "Mon, 02 Jan 2006 15:04:05 GMT"}, 200},
+	}
+	var runCases = func(state string) {
+		for i, c := range cases {
+			h := http.Header{}
+			for k, v := range c.hdr {
+				h.Set(k, v)
+			}
+			w := getResponseWithHeader(handler, c.url, h)
+			if w.Code != c.status {
+				t.Fatalf("Status should have been %d for case %d (%s), got %d", c.status, i+1, state, w.Code)
+			}
+			if w.Header().Get("microcache") != state {
+				t.Fatalf("Response should have been %s for case %d", state, i+1)
+			}
+			if c.status == 304 && (w.Body.Len() > 0 || w.Header().Get("Etag") == "") {
+				t.Fatalf("Not modified response malformed for case %d", i+1)
+			}
+		}
+	}
+	runCases("HIT")
+	cache.offsetIncr(31 * time.Second)
+	runCases("STALE")
+}
+
+// Revalidate should reuse the stored body when the backend answers 304
+func TestRevalidate(t *testing.T) {
+	testMonitor := &monitorFunc{interval: 100 * time.Second, logFunc: func(Stats) {}}
+	cache := New(Config{
+		TTL:        30 * time.Second,
+		Revalidate: true,
+		Monitor:    testMonitor,
+		Driver:     NewDriverLRU(10),
+		Exposed:    true,
+	})
+	defer cache.Stop()
+	var renders int
+	handler := cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Etag", `"v1"`)
+		if r.URL.Path == "/swr" {
+			w.Header().Set("microcache-stale-while-revalidate", "30")
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		renders++
+		w.Write([]byte("done"))
+	}))
+
+	// Foreground revalidation
+	batchGet(handler, []string{"/"})
+	cache.offsetIncr(31 * time.Second)
+	w := getResponse(handler, "/")
+	if w.Code != 200 || w.Body.String() != "done" || w.Header().Get("microcache") != "MISS" {
+		t.Fatal("Revalidated response should contain stored body")
+	}
+	w = getResponse(handler, "/")
+	if w.Header().Get("microcache") != "HIT" || renders != 1 || testMonitor.getBackends() != 2 {
+		t.Fatalf("Revalidated response should be refreshed %s", dumpMonitor(testMonitor))
+	}
+
+	// Client validators are answered from the refreshed object
+	cache.offsetIncr(31 * time.Second)
+	w = getResponseWithHeader(handler, "/", http.Header{"If-None-Match": []string{`"v1"`}})
+	if w.Code != 304 || renders != 1 {
+		t.Fatal("Revalidated response should honor client validators")
+	}
+
+	// Background revalidation
+	batchGet(handler, []string{"/swr"})
+	cache.offsetIncr(31 * time.Second)
+	w = getResponse(handler, "/swr")
+	time.Sleep(10 * time.Millisecond)
+	if w.Header().Get("microcache") != "STALE" {
+		t.Fatal("Stale response should have been served")
+	}
+	w = getResponse(handler, "/swr")
+	if w.Header().Get("microcache") != "HIT" || w.Body.String() != "done" || renders != 2 {
+		t.Fatal("Background revalidation should refresh stored response")
+	}
+}
+
+// Revalidated responses should be updated with the headers of the 304
+func TestRevalidateHeaders(t *testing.T) {
+	cache := New(Config{
+		TTL:                  30 * time.Second,
+		Revalidate:           true,
+		ResponseCacheControl: true,
+		Driver:               NewDriverLRU(10),
+		Exposed:              true,
+	})
+	defer cache.Stop()
+	handler := cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("X-Version", "2")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=10")
+		w.Header().Set("Etag", `"v1"`)
+		w.Header().Set("X-Version", "1")
+		w.Write([]byte("done"))
+	}))
+	batchGet(handler, []string{"/"})
+	cache.offsetIncr(11 * time.Second)
+	w := getResponse(handler, "/")
+	if w.Body.String() != "done" || w.Header().Get("X-Version") != "2" || w.Header().Get("Etag") != `"v1"` {
+		t.Fatal("Revalidated response should be updated with the headers of the 304")
+	}
+	cache.offsetIncr(30 * time.Second)
+	w = getResponse(handler, "/")
+	if w.Header().Get("microcache") != "HIT" || w.Header().Get("X-Version") != "2" {
+		t.Fatal("Revalidated response should be refreshed for the ttl of the 304")
+	}
+}
+
+// Client validators should not reach the backend on a miss
+func TestConditionalMiss(t *testing.T) {
+	cache := New(Config{
+		TTL:     30 * time.Second,
+		Driver:  NewDriverLRU(10),
+		Exposed: true,
+	})
+	defer cache.Stop()
+	modified := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	handler := cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Etag", `"v1"`)
+		http.ServeContent(w, r, "", modified, strings.NewReader("hello"))
+	}))
+	w := getResponseWithHeader(handler, "/", http.Header{"If-None-Match": []string{`"v1"`}})
+	if w.Code != 304 || w.Header().Get("microcache") != "MISS" {
+		t.Fatalf("Conditional miss should be answered with 304, got %d", w.Code)
+	}
+	w = getResponse(handler, "/")
+	if w.Code != 200 || w.Body.String() != "hello" || w.Header().Get("microcache") != "HIT" {
+		t.Fatalf("Conditional miss should store the full response, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+// Client Cache-Control directives should be honored
+func TestRequestCacheControl(t *testing.T) {
+	testMonitor := &monitorFunc{interval: 100 * time.Second, logFunc: func(Stats) {}}
+	cache := New(Config{
+		TTL:                  30 * time.Second,
+		StaleWhileRevalidate: 30 * time.Second,
+		RequestCacheControl:  true,
+		RequestCacheControlAllow: func(r *http.Request) bool {
+			return r.Header.Get("x-deny") == ""
+		},
+		Monitor: testMonitor,
+		Driver:  NewDriverLRU(10),
+		Exposed: true,
+	})
+	defer cache.Stop()
+	handler := cache.Middleware(http.HandlerFunc(noopSuccessHandler))
+	cases := []struct {
+		url    string
+		hdr    map[string]string
+		offset time.Duration
+		state  string
+		status int
+	}{
+		{"/", map[string]string{"Cache-Control": "only-if-cached"}, 0, "MISS", 504},
+		{"/", map[string]string{}, 0, "MISS", 200},
+		{"/", map[string]string{}, 0, "HIT", 200},
+		{"/", map[string]string{"Cache-Control": "no-cache"}, 0, "MISS", 200},
+		{"/", map[string]string{"Cache-Control": "no-cache", "x-deny": "1"}, 0, "HIT", 200},
+		{"/", map[string]string{"Pragma": "no-cache"}, 0, "MISS", 200},
+		{"/", map[string]string{"Cache-Control": "max-age=0"}, 0, "MISS", 200},
+		{"/", map[string]string{"Cache-Control": "max-age=10"}, 5 * time.Second, "HIT", 200},
+		{"/", map[string]string{"Cache-Control": "max-age=10"}, 10 * time.Second, "MISS", 200},
+		{"/", map[string]string{"Cache-Control": "min-fresh=20"}, 0, "HIT", 200},
+		{"/", map[string]string{"Cache-Control": "min-fresh=20"}, 15 * time.Second, "MISS", 200},
+		{"/", map[string]string{"Cache-Control": "only-if-cached"}, 0, "HIT", 200},
+		{"/", map[string]string{"Cache-Control": "max-stale=60"}, 80 * time.Second, "STALE", 200},
+		{"/", map[string]string{"Cache-Control": "max-stale"}, 600 * time.Second, "STALE", 200},
+		{"/", map[string]string{"Cache-Control": "max-stale=60"}, 0, "MISS", 200},
+		{"/", map[string]string{"Cache-Control": "only-if-cached"}, 600 * time.Second, "MISS", 504},
+	}
+	for i, c := range cases {
+		h := http.Header{}
+		for k, v := range c.hdr {
+			h.Set(k, v)
+		}
+		cache.offsetIncr(c.offset)
+		w := getResponseWithHeader(handler, c.url, h)
+		if w.Header().Get("microcache") != c.state || w.Code != c.status {
+			t.Fatalf("Response should have been %s %d for case %d, got %s %d",
+				c.state, c.status, i+1, w.Header().Get("microcache"), w.Code)
+		}
+	}
+}
+
+// Responses exceeding MaxBodySize should be streamed and not cached
+func TestMaxBodySize(t *testing.T) {
+	testMonitor := &monitorFunc{interval: 100 * time.Second, logFunc: func(Stats) {}}
+	cache := New(Config{
+		TTL:         30 * time.Second,
+		MaxBodySize: 10,
+		Monitor:     testMonitor,
+		Driver:      NewDriverLRU(10),
+		Exposed:     true,
+	})
+	defer cache.Stop()
+	var captured bool
+	handler := cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, captured = w.(*Response)
+		w.Header().Set("microcache-ttl", "10")
+		w.Header().Set("Content-Type", "text/plain")
+		if r.URL.Path == "/small" {
+			w.Write([]byte("0123456789"))
+			return
+		}
+		w.WriteHeader(201)
+		for i := 0; i < 3; i++ {
+			w.Write([]byte("0123456789"))
+		}
+	}))
+	for i := 0; i < 2; i++ {
+		w := getResponse(handler, "/large")
+		if w.Code != 201 || w.Body.String() != strings.Repeat("0123456789", 3) ||
+			w.Header().Get("Content-Type") != "text/plain" || w.Header().Get("microcache-ttl") != "" {
+			t.Fatalf("Large response should be streamed to client, got %d %q", w.Code, w.Body.String())
+		}
+		if w.Header().Get("microcache") != "MISS" || !captured {
+			t.Fatal("Large response should not be cached")
+		}
+	}
+	batchGet(handler, []string{"/small", "/small"})
+	if testMonitor.getMisses() != 3 || testMonitor.getHits() != 1 {
+		t.Fatalf("Small response should be cached %s", dumpMonitor(testMonitor))
+	}
+
+	// MaxBodySizeNocache
+	cache.MaxBodySizeNocache = true
+	batchGet(handler, []string{"/nocache"})
+	if !captured {
+		t.Fatal("Response substitution should have occurred")
+	}
+	w := getResponse(handler, "/nocache")
+	if captured || w.Body.Len() != 30 {
+		t.Fatal("Request should pass through after exceeding MaxBodySize")
+	}
+}
+
+// StreamMisses should send responses to the client while they are being captured
+func TestStreamMisses(t *testing.T) {
+	cache := New(Config{
+		TTL:          30 * time.Second,
+		StreamMisses: true,
+		Driver:       NewDriverLRU(10),
+		Exposed:      true,
+	})
+	defer cache.Stop()
+	var client *httptest.ResponseRecorder
+	var streamed bool
+	var cancel context.CancelFunc
+	handler := cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("microcache-ttl", "10")
+		w.Write([]byte("chunk1"))
+		w.(http.Flusher).Flush()
+		streamed = client.Body.String() == "chunk1" && client.Flushed
+		if r.URL.Path == "/fail" {
+			cancel()
+		}
+		w.Write([]byte("chunk2"))
+	}))
+	var get = func(url string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", url, nil)
+		ctx, c := context.WithCancel(r.Context())
+		cancel = c
+		client = httptest.NewRecorder()
+		handler.ServeHTTP(client, r.WithContext(ctx))
+		return client
+	}
+	w := get("/")
+	if !streamed || w.Body.String() != "chunk1chunk2" || w.Header().Get("microcache") != "MISS" ||
+		w.Header().Get("microcache-ttl") != "" {
+		t.Fatal("Response should have been streamed to the client")
+	}
+	streamed = false
+	w = get("/")
+	if streamed || w.Body.String() != "chunk1chunk2" || w.Header().Get("microcache") != "HIT" {
+		t.Fatal("Streamed response should have been cached")
+	}
+
+	// Cache write is abandoned when the request fails partway
+	get("/fail")
+	w = get("/fail")
+	if !streamed || w.Header().Get("microcache") != "MISS" {
+		t.Fatal("Failed response should not have been cached")
+	}
+}
+
+// Cache entries should be method aware
+func TestMethods(t *testing.T) {
+	cache := New(Config{
+		TTL:     30 * time.Second,
+		Driver:  NewDriverLRU(10),
+		Exposed: true,
+	})
+	defer cache.Stop()
+	handler := cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			return
+		}
+		w.Write([]byte(r.Method))
+	}))
+	cases := []struct {
+		url    string
+		method string
+		state  string
+		body   string
+	}{
+		{"/a", "HEAD", "MISS", ""},
+		{"/a", "GET", "MISS", "GET"},
+		{"/a", "GET", "HIT", "GET"},
+		{"/a", "HEAD", "HIT", ""},
+		{"/a", "OPTIONS", "MISS", "OPTIONS"},
+		{"/a", "OPTIONS", "HIT", "OPTIONS"},
+		{"/a", "GET", "HIT", "GET"},
+		{"/b", "OPTIONS", "MISS", "OPTIONS"},
+		{"/b", "HEAD", "MISS", ""},
+		{"/b", "GET", "MISS", "GET"},
+	}
+	for i, c := range cases {
+		w := getResponseWithMethod(handler, c.url, c.method)
+		if w.Header().Get("microcache") != c.state || w.Body.String() != c.body {
+			t.Fatalf("Response should have been %s %q for case %d, got %s %q",
+				c.state, c.body, i+1, w.Header().Get("microcache"), w.Body.String())
+		}
+	}
+	w := getResponseWithMethod(handler, "/a", "HEAD")
+	if w.Header().Get("Content-Length") != "3" {
+		t.Fatal("HEAD response should contain the length of the cached body")
+	}
+}
+
+// Unsafe requests should invalidate all variants and referenced locations
+func TestUnsafeInvalidation(t *testing.T) {
+	// Variants split by the Vary response header and by Config.Vary
+	for _, vary := range [][]string{nil, {"Accept-Language"}} {
+		testUnsafeInvalidation(t, vary)
+	}
+}
+
+func testUnsafeInvalidation(t *testing.T, vary []string) {
+	cache := New(Config{
+		TTL:     30 * time.Second,
+		Vary:    vary,
+		Driver:  NewDriverLRU(10),
+		Exposed: true,
+	})
+	defer cache.Stop()
+	handler := cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if vary == nil {
+			w.Header().Set("Vary", "Accept-Language")
+		}
+		if r.Method == "POST" {
+			w.Header().Set("Location", "/items/1")
+			w.Header().Set("Content-Location", "http://other.example/items/2")
+			w.WriteHeader(201)
+		}
+	}))
+	var get = func(url, lang string) string {
+		w := getResponseWithHeader(handler, url, http.Header{"Accept-Language": []string{lang}})
+		return w.Header().Get("microcache")
+	}
+	for _, url := range []string{"/items", "/items/1", "/items/2"} {
+		for _, lang := range []string{"en", "fr"} {
+			get(url, lang)
+			if get(url, lang) != "HIT" {
+				t.Fatalf("%s %s should have been cached", url, lang)
+			}
+		}
+	}
+	r, _ := http.NewRequest("POST", "/items", nil)
+	r.Header.Set("Accept-Language", "en")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	cases := []struct {
+		url   string
+		lang  string
+		state string
+	}{
+		{"/items", "fr", "MISS"},
+		{"/items", "fr", "HIT"},
+		{"/items", "en", "MISS"},
+		{"/items/1", "fr", "MISS"},
+		{"/items/1", "en", "MISS"},
+		{"/items/2", "fr", "HIT"},
+	}
+	for i, c := range cases {
+		if state := get(c.url, c.lang); state != c.state {
+			t.Fatalf("Response should have been %s for case %d (vary %v), got %s", c.state, i+1, vary, state)
+		}
+	}
+}
+
+// Selected error responses should be cached for NegativeTTL
+func TestNegativeCaching(t *testing.T) {
+	testMonitor := &monitorFunc{interval: 100 * time.Second, logFunc: func(Stats) {}}
+	cache := New(Config{
+		TTL:         30 * time.Second,
+		NegativeTTL: 10 * time.Second,
+		Monitor:     testMonitor,
+		Driver:      NewDriverLRU(10),
+		Exposed:     true,
+	})
+	defer cache.Stop()
+	handler := cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gone":
+			w.Header().Set("microcache-negative-ttl", "60")
+			http.Error(w, "gone", 410)
+		case "/error":
+			http.Error(w, "error", 500)
+		case "/forbidden":
+			http.Error(w, "forbidden", 403)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	cases := []struct {
+		url    string
+		offset time.Duration
+		state  string
+	}{
+		{"/missing", 0, "MISS"},
+		{"/missing", 0, "HIT"},
+		{"/gone", 0, "MISS"},
+		{"/gone", 0, "HIT"},
+		{"/error", 0, "MISS"},
+		{"/error", 0, "MISS"},
+		{"/forbidden", 0, "MISS"},
+		{"/forbidden", 0, "MISS"},
+		{"/missing", 10 * time.Second, "MISS"},
+		{"/gone", 0, "HIT"},
+	}
+	for i, c := range cases {
+		cache.offsetIncr(c.offset)
+		w := getResponse(handler, c.url)
+		if w.Header().Get("microcache") != c.state || w.Code < 400 {
+			t.Fatalf("Response should have been %s for case %d, got %s %d",
+				c.state, i+1, w.Header().Get("microcache"), w.Code)
+		}
+	}
+	if testMonitor.getNegatives() != 3 || testMonitor.getHits() != 0 {
+		t.Fatalf("Negative hits should be reported separately %s", dumpMonitor(testMonitor))
+	}
+}
+
+// Request options built from negative responses should not apply to success responses
+func TestNegativeCachingRequestOpts(t *testing.T) {
+	cache := New(Config{
+		TTL:         30 * time.Second,
+		NegativeTTL: 10 * time.Second,
+		Driver:      NewDriverLRU(10),
+		Exposed:     true,
+	})
+	defer cache.Stop()
+	var found bool
+	handler := cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("microcache-nocache", "1")
+		w.Write([]byte(r.Header.Get("User")))
+	}))
+	batchGet(handler, []string{"/", "/"})
+	cache.offsetIncr(10 * time.Second)
+	found = true
+	for _, user := range []string{"alice", "bob"} {
+		w := getResponseWithHeader(handler, "/", http.Header{"User": []string{user}})
+		if w.Body.String() != user || w.Header().Get("microcache") == "HIT" {
+			t.Fatalf("Uncacheable response should not be served from cache, got %s %q",
+				w.Header().Get("microcache"), w.Body.String())
+		}
+	}
+}
+
+// RefreshAhead should revalidate fresh responses nearing expiration
+func TestRefreshAhead(t *testing.T) {
+	testMonitor := &monitorFunc{interval: 100 * time.Second, logFunc: func(Stats) {}}
+	cache := New(Config{
+		TTL:          30 * time.Second,
+		RefreshAhead: 0.2,
+		Monitor:      testMonitor,
+		Driver:       NewDriverLRU(10),
+	})
+	defer cache.Stop()
+	handler := cache.Middleware(http.HandlerFunc(noopSuccessHandler))
+	var check = func(offset time.Duration, backends int) {
+		cache.offsetIncr(offset)
+		batchGet(handler, []string{"/"})
+		time.Sleep(10 * time.Millisecond)
+		if testMonitor.getBackends() != backends || testMonitor.getMisses() != 1 {
+			t.Fatal("RefreshAhead not respected - got", testMonitor.getBackends(), "backend requests")
+		}
+	}
+	check(0, 1)
+	check(20*time.Second, 1)
+	check(5*time.Second, 2)
+	check(10*time.Second, 2)
+	check(15*time.Second, 3)
+
+	// XFetch
+	testMonitor = &monitorFunc{interval: 100 * time.Second, logFunc: func(Stats) {}}
+	cache = New(Config{
+		TTL:              30 * time.Second,
+		RefreshAheadBeta: 1e15,
+		Monitor:          testMonitor,
+		Driver:           NewDriverLRU(10),
+	})
+	defer cache.Stop()
+	handler = cache.Middleware(http.HandlerFunc(noopSuccessHandler))
+	check(0, 1)
+	check(0, 2)
+
+	// Negative responses
+	testMonitor = &monitorFunc{interval: 100 * time.Second, logFunc: func(Stats) {}}
+	cache = New(Config{
+		TTL:          30 * time.Second,
+		NegativeTTL:  5 * time.Second,
+		RefreshAhead: 0.2,
+		Monitor:      testMonitor,
+		Driver:       NewDriverLRU(10),
+	})
+	defer cache.Stop()
+	handler = cache.Middleware(http.HandlerFunc(http.NotFound))
+	check(0, 1)
+	check(0, 1)
+	check(4*time.Second, 1)
+}
+
 // Stop
 func TestStop(t *testing.T) {
 	cache := New(Config{})
@@ -781,3 +1352,22 @@ func dumpMonitor(m *monitorFunc) string {
 		m.getErrors(),
 	)
 }
+
+// Handlers of unsafe requests should be able to flush and hijack
+func TestUnsafeWriter(t *testing.T) {
+	cache := New(Config{
+		TTL:    30 * time.Second,
+		Driver: NewDriverLRU(10),
+	})
+	defer cache.Stop()
+	var flusher, hijacker bool
+	handler := cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, flusher = w.(http.Flusher)
+		_, hijacker = w.(http.Hijacker)
+	}))
+	r, _ := http.NewRequest("POST", "/", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if !flusher || !hijacker {
+		t.Fatal("Unsafe requests should support http.Flusher and http.Hijacker")
+	}
+}
@@ -16,12 +16,27 @@ type Monitor interface {
 	Collision()
 }
 
+// DriverErrorMonitor is implemented by monitors which count failed driver
+// calls (see DriverV2)
+type DriverErrorMonitor interface {
+	DriverError()
+}
+
+// NegativeMonitor is implemented by monitors which count hits on negatively
+// cached responses (see Config.NegativeTTL) separately from other hits.
+// Other monitors count them as hits.
+type NegativeMonitor interface {
+	Negative()
+}
+
 type Stats struct {
-	Size       int
-	Hits       int
-	Misses     int
-	Stales     int
-	Backend    int
-	Errors     int
-	Collisions int
+	Size         int
+	Hits         int
+	Misses       int
+	Stales       int
+	Backend      int
+	Errors       int
+	Collisions   int
+	Negatives    int
+	DriverErrors int
 }
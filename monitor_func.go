@@ -22,6 +22,8 @@ type monitorFunc struct {
 	backend    int64
 	errors     int64
 	collisions int64
+	negatives  int64
+	driverErrs int64
 	stop       chan bool
 }
 
@@ -48,6 +50,12 @@ func (m *monitorFunc) Log(stats Stats) {
 	// collisions
 	stats.Collisions = int(atomic.SwapInt64(&m.collisions, 0))
 
+	// negatives
+	stats.Negatives = int(atomic.SwapInt64(&m.negatives, 0))
+
+	// driver errors
+	stats.DriverErrors = int(atomic.SwapInt64(&m.driverErrs, 0))
+
 	// log
 	m.logFunc(stats)
 }
@@ -76,6 +84,28 @@ func (m *monitorFunc) Collision() {
 	atomic.AddInt64(&m.collisions, 1)
 }
 
+func (m *monitorFunc) Negative() {
+	atomic.AddInt64(&m.negatives, 1)
+}
+
+func (m *monitorFunc) DriverError() {
+	atomic.AddInt64(&m.driverErrs, 1)
+}
+
+// peek returns the stats collected since the last call to Log
+func (m *monitorFunc) peek() Stats {
+	return Stats{
+		Hits:         m.getHits(),
+		Misses:       m.getMisses(),
+		Stales:       m.getStales(),
+		Backend:      m.getBackends(),
+		Errors:       m.getErrors(),
+		Collisions:   int(atomic.LoadInt64(&m.collisions)),
+		Negatives:    m.getNegatives(),
+		DriverErrors: m.getDriverErrors(),
+	}
+}
+
 func (m *monitorFunc) getHits() int {
 	return int(atomic.LoadInt64(&m.hits))
 }
@@ -95,3 +125,11 @@ func (m *monitorFunc) getBackends() int {
 func (m *monitorFunc) getErrors() int {
 	return int(atomic.LoadInt64(&m.errors))
 }
+
+func (m *monitorFunc) getNegatives() int {
+	return int(atomic.LoadInt64(&m.negatives))
+}
+
+func (m *monitorFunc) getDriverErrors() int {
+	return int(atomic.LoadInt64(&m.driverErrs))
+}
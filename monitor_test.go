@@ -39,3 +39,34 @@ func TestMicrocacheCallsMonitor(t *testing.T) {
 		t.Fatal("Monitor was not called by microcache")
 	}
 }
+
+// basicMonitor implements none of the optional monitor interfaces
+type basicMonitor struct {
+	hits int
+}
+
+func (m *basicMonitor) GetInterval() time.Duration { return 100 * time.Second }
+func (m *basicMonitor) Log(Stats)                  {}
+func (m *basicMonitor) Hit()                       { m.hits++ }
+func (m *basicMonitor) Miss()                      {}
+func (m *basicMonitor) Stale()                     {}
+func (m *basicMonitor) Backend()                   {}
+func (m *basicMonitor) Error()                     {}
+func (m *basicMonitor) Collision()                 {}
+
+// Negative hits should be counted as hits by monitors without NegativeMonitor
+func TestMonitorOptional(t *testing.T) {
+	testMonitor := &basicMonitor{}
+	cache := New(Config{
+		TTL:         30 * time.Second,
+		NegativeTTL: 10 * time.Second,
+		Monitor:     testMonitor,
+		Driver:      NewDriverLRU(10),
+	})
+	defer cache.Stop()
+	handler := cache.Middleware(http.HandlerFunc(http.NotFound))
+	batchGet(handler, []string{"/", "/"})
+	if testMonitor.hits != 1 {
+		t.Fatalf("Negative hits should be counted as hits, got %d", testMonitor.hits)
+	}
+}
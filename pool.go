@@ -0,0 +1,100 @@
+package microcache
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"time"
+)
+
+// connPool maintains idle connections to a server for network drivers
+type connPool struct {
+	addr    string
+	timeout time.Duration
+	idle    chan *poolConn
+
+	// init prepares new connections, e.g. by authenticating
+	init func(c *poolConn) error
+}
+
+type poolConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// replyError is implemented by errors reported by a server in reply to a
+// command. Connections remain usable after such errors.
+type replyError interface {
+	error
+	reply()
+}
+
+func newConnPool(addr string, size int, timeout time.Duration, init func(c *poolConn) error) *connPool {
+	return &connPool{
+		addr:    addr,
+		timeout: timeout,
+		idle:    make(chan *poolConn, size),
+		init:    init,
+	}
+}
+
+func (p *connPool) get(ctx context.Context) (*poolConn, error) {
+	select {
+	case c := <-p.idle:
+		return c, nil
+	default:
+	}
+	dialer := net.Dialer{Timeout: p.timeout}
+	nc, err := dialer.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &poolConn{nc, bufio.NewReader(nc), bufio.NewWriter(nc)}
+	if p.init != nil {
+		c.SetDeadline(p.deadline(ctx))
+		if err = p.init(c); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (p *connPool) put(c *poolConn) {
+	select {
+	case p.idle <- c:
+	default:
+		c.Close()
+	}
+}
+
+// deadline returns the earlier of the pool timeout and the context deadline
+func (p *connPool) deadline(ctx context.Context) time.Time {
+	deadline := time.Now().Add(p.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		return d
+	}
+	return deadline
+}
+
+// do calls f with a pooled connection which must respond within the pool timeout
+// and before the context deadline.
+// Connections are discarded after network and protocol errors.
+func (p *connPool) do(ctx context.Context, f func(c *poolConn) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c, err := p.get(ctx)
+	if err != nil {
+		return err
+	}
+	c.SetDeadline(p.deadline(ctx))
+	err = f(c)
+	if _, ok := err.(replyError); err != nil && !ok {
+		c.Close()
+		return err
+	}
+	p.put(c)
+	return err
+}
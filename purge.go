@@ -0,0 +1,232 @@
+package microcache
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrNotIterable is returned by operations which require an IterableDriver
+var ErrNotIterable = errors.New("microcache: driver does not implement IterableDriver")
+
+// Purge invalidates all cached responses for the path of a URL including all
+// variants by header and query parameters. It returns the number of
+// requests invalidated.
+// Drivers which do not implement IterableDriver can only invalidate the
+// exact URL for requests which carry none of the Config.Vary headers.
+func (m *microcache) Purge(rawurl string) (int, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return 0, err
+	}
+	return m.purgeRequest(&http.Request{Method: "GET", URL: u, Header: http.Header{}}, false), nil
+}
+
+// SoftPurge marks all cached responses for the path of a URL stale like Purge.
+// Stale responses are revalidated by the next request but remain available
+// to StaleIfError and StaleWhileRevalidate.
+func (m *microcache) SoftPurge(rawurl string) (int, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return 0, err
+	}
+	return m.purgeRequest(&http.Request{Method: "GET", URL: u, Header: http.Header{}}, true), nil
+}
+
+// purgeRequest invalidates all cached responses for the path of a request
+func (m *microcache) purgeRequest(r *http.Request, soft bool) int {
+	if _, ok := iterableDriver(m.Driver); !ok {
+		reqHash := getRequestHash(m, r)
+		req, _, _ := m.getRequestOpts(r.Context(), reqHash)
+		if !req.found {
+			return 0
+		}
+		m.invalidate(reqHash, req, soft)
+		return 1
+	}
+	n, _ := m.purgeMatch(func(path string) bool {
+		return path == r.URL.Path
+	}, soft)
+	return n
+}
+
+// PurgePrefix invalidates all cached responses for URL paths beginning with prefix.
+// It returns the number of requests invalidated.
+// Returns ErrNotIterable if the driver does not implement IterableDriver.
+func (m *microcache) PurgePrefix(prefix string) (int, error) {
+	return m.purgeMatch(func(path string) bool {
+		return strings.HasPrefix(path, prefix)
+	}, false)
+}
+
+// SoftPurgePrefix marks all cached responses for URL paths beginning with
+// prefix stale. Returns ErrNotIterable if the driver does not implement IterableDriver.
+func (m *microcache) SoftPurgePrefix(prefix string) (int, error) {
+	return m.purgeMatch(func(path string) bool {
+		return strings.HasPrefix(path, prefix)
+	}, true)
+}
+
+// PurgeAll invalidates all cached responses.
+// Requests held by drivers implementing IterableDriver are also invalidated
+// individually so that the purge persists in drivers which outlive the process.
+func (m *microcache) PurgeAll() {
+	m.purgeMutex.Lock()
+	m.purgedAll = time.Now()
+	m.purgeMutex.Unlock()
+	m.purgeMatch(matchAll, false)
+}
+
+// SoftPurgeAll marks all cached responses stale like PurgeAll
+func (m *microcache) SoftPurgeAll() {
+	m.purgeMutex.Lock()
+	m.softPurgedAll = time.Now()
+	m.purgeMutex.Unlock()
+	m.purgeMatch(matchAll, true)
+}
+
+func matchAll(path string) bool {
+	return true
+}
+
+// purgeMatch invalidates all requests whose path matches. Hard purges also
+// remove the response objects stored for them to free their memory.
+func (m *microcache) purgeMatch(match func(path string) bool, soft bool) (int, error) {
+	d, ok := iterableDriver(m.Driver)
+	if !ok {
+		return 0, ErrNotIterable
+	}
+	matches := map[string]RequestOpts{}
+	d.RangeRequestOpts(func(hash string, req RequestOpts) bool {
+		if match(req.path) {
+			matches[hash] = req
+		}
+		return true
+	})
+	for hash, req := range matches {
+		m.invalidate(hash, req, soft)
+	}
+	if !soft && len(matches) > 0 {
+		var purged []string
+		d.Range(func(hash string, res Response) bool {
+			if _, ok := matches[res.reqHash]; ok {
+				purged = append(purged, hash)
+			}
+			return true
+		})
+		for _, hash := range purged {
+			m.remove(hash)
+		}
+	}
+	return len(matches), nil
+}
+
+// applyPurges discards a response stored before its request or the whole
+// cache was purged and expires a response stored before it was soft purged
+func (m *microcache) applyPurges(req RequestOpts, obj Response) Response {
+	m.purgeMutex.RLock()
+	defer m.purgeMutex.RUnlock()
+	if !obj.date.After(req.purged) || !obj.date.After(m.purgedAll) {
+		return Response{}
+	}
+	softPurged := req.softPurged
+	if m.softPurgedAll.After(softPurged) {
+		softPurged = m.softPurgedAll
+	}
+	if !obj.date.After(softPurged) {
+		// Stale since the time of the purge
+		expires := m.now().Add(softPurged.Sub(time.Now()))
+		if expires.Before(obj.expires) {
+			obj.expires = expires
+		}
+	}
+	return obj
+}
+
+// invalidate marks all response objects stored for a request hash as invalid,
+// regardless of the header and query parameter values they vary by.
+// Soft invalidation marks them stale instead.
+func (m *microcache) invalidate(reqHash string, req RequestOpts, soft bool) {
+	if !req.found {
+		return
+	}
+	if soft {
+		req.softPurged = time.Now()
+	} else {
+		req.purged = time.Now()
+	}
+	m.setRequestOpts(reqHash, req)
+}
+
+// invalidateLocations invalidates the URLs referenced by the Location and
+// Content-Location headers of a response to an unsafe request when they
+// share the origin of the request (RFC 9111 §4.4)
+func (m *microcache) invalidateLocations(r *http.Request, header http.Header) {
+	for _, name := range []string{"Location", "Content-Location"} {
+		loc := header.Get(name)
+		if loc == "" {
+			continue
+		}
+		u, err := url.Parse(loc)
+		if err != nil {
+			continue
+		}
+		u = r.URL.ResolveReference(u)
+		if u.Host != "" && u.Host != r.Host && u.Host != r.URL.Host {
+			continue
+		}
+		lr := r.Clone(r.Context())
+		lr.Method = "GET"
+		lr.URL = u
+		reqHash := getRequestHash(m, lr)
+		req, _, _ := m.getRequestOpts(lr.Context(), reqHash)
+		m.invalidateRequest(lr, reqHash, req, m.SoftInvalidation)
+	}
+}
+
+// invalidateRequest invalidates the cached responses for the path of a request.
+// Variants split by Config.Vary have distinct request hashes, so they are only
+// found when the driver implements IterableDriver.
+func (m *microcache) invalidateRequest(r *http.Request, reqHash string, req RequestOpts, soft bool) {
+	if _, ok := iterableDriver(m.Driver); ok && len(m.Vary) > 0 {
+		m.purgeRequest(r, soft)
+		return
+	}
+	m.invalidate(reqHash, req, soft)
+}
+
+// servePurge answers requests using PurgeMethod or BanMethod.
+// Responses are soft purged if Config.SoftInvalidation is set.
+// PURGE invalidates the requested URL. BAN invalidates all URLs with paths
+// matching the regular expression in the X-Ban-Url request header.
+func (m *microcache) servePurge(w http.ResponseWriter, r *http.Request) {
+	if m.PurgeAuthorizer == nil || !m.PurgeAuthorizer(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	var n int
+	if r.Method == m.PurgeMethod {
+		n = m.purgeRequest(r, m.SoftInvalidation)
+	} else {
+		pattern := r.Header.Get("X-Ban-Url")
+		re, err := regexp.Compile(pattern)
+		if err != nil || pattern == "" {
+			http.Error(w, "Invalid X-Ban-Url", http.StatusBadRequest)
+			return
+		}
+		n, err = m.purgeMatch(re.MatchString, m.SoftInvalidation)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+	}
+	if n == 0 {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, fmt.Sprintf("Purged %d", n), http.StatusOK)
+}
@@ -0,0 +1,210 @@
+package microcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Purge, PurgePrefix and PurgeAll should invalidate cached responses
+func TestPurge(t *testing.T) {
+	cache := New(Config{
+		TTL:       30 * time.Second,
+		HashQuery: true,
+		Vary:      []string{"Accept-Language"},
+		Driver:    NewDriverLRU(20),
+		Exposed:   true,
+	})
+	defer cache.Stop()
+	handler := cache.Middleware(http.HandlerFunc(noopSuccessHandler))
+	urls := []string{"/a", "/a?page=2", "/a/b", "/c"}
+	var prime = func() {
+		for _, url := range urls {
+			for _, lang := range []string{"en", "fr"} {
+				getResponseWithHeader(handler, url, http.Header{"Accept-Language": []string{lang}})
+			}
+		}
+	}
+	var hits = func() (hits []string) {
+		for _, url := range urls {
+			for _, lang := range []string{"en", "fr"} {
+				w := getResponseWithHeader(handler, url, http.Header{"Accept-Language": []string{lang}})
+				if w.Header().Get("microcache") == "HIT" {
+					hits = append(hits, url+" "+lang)
+				}
+			}
+		}
+		return hits
+	}
+	var check = func(name string, n, expected int, expectedHits []string) {
+		got := hits()
+		if n != expected || len(got) != len(expectedHits) {
+			t.Fatalf("%s should purge %d requests and leave %v cached, got %d %v", name, expected, expectedHits, n, got)
+		}
+		for i := range got {
+			if got[i] != expectedHits[i] {
+				t.Fatalf("%s should leave %v cached, got %v", name, expectedHits, got)
+			}
+		}
+	}
+
+	prime()
+	n, err := cache.Purge("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := cache.Driver.GetSize(); size != 4 {
+		t.Fatalf("Purge should remove purged response objects, got %d", size)
+	}
+	check("Purge", n, 4, []string{"/a/b en", "/a/b fr", "/c en", "/c fr"})
+
+	prime()
+	n, err = cache.PurgePrefix("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("PurgePrefix", n, 6, []string{"/c en", "/c fr"})
+
+	prime()
+	cache.PurgeAll()
+	if size := cache.Driver.GetSize(); size != 0 {
+		t.Fatalf("PurgeAll should remove purged response objects, got %d", size)
+	}
+	check("PurgeAll", 0, 0, nil)
+
+	prime()
+	if _, err = cache.Purge("%zz"); err == nil {
+		t.Fatal("Purge should fail on invalid URL")
+	}
+}
+
+// Purge should fall back to the exact URL when the driver is not iterable
+func TestPurgeNotIterable(t *testing.T) {
+	cache := New(Config{
+		TTL:     30 * time.Second,
+		Driver:  struct{ Driver }{NewDriverLRU(10)},
+		Exposed: true,
+	})
+	defer cache.Stop()
+	handler := cache.Middleware(http.HandlerFunc(noopSuccessHandler))
+	batchGet(handler, []string{"/a"})
+	if _, err := cache.PurgePrefix("/"); err != ErrNotIterable {
+		t.Fatal("PurgePrefix should require an IterableDriver")
+	}
+	if n, _ := cache.Purge("/a"); n != 1 {
+		t.Fatal("Purge should invalidate exact URL")
+	}
+	if getResponse(handler, "/a").Header().Get("microcache") != "MISS" {
+		t.Fatal("Purged response should not be served")
+	}
+}
+
+// PURGE and BAN requests should be authorized and answered by the middleware
+func TestPurgeMethods(t *testing.T) {
+	var backend int
+	cache := New(Config{
+		TTL:         30 * time.Second,
+		Driver:      NewDriverLRU(10),
+		Exposed:     true,
+		PurgeMethod: "PURGE",
+		BanMethod:   "BAN",
+		PurgeAuthorizer: func(r *http.Request) bool {
+			return r.Header.Get("Authorization") == "secret"
+		},
+	})
+	defer cache.Stop()
+	handler := cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backend++
+	}))
+	auth := http.Header{"Authorization": []string{"secret"}}
+	var purge = func(method, url string, h http.Header) int {
+		r, _ := http.NewRequest(method, url, nil)
+		r.Header = h
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	batchGet(handler, []string{"/a", "/a/b", "/c"})
+	backend = 0
+
+	if code := purge("PURGE", "/a", http.Header{}); code != 403 {
+		t.Fatalf("Unauthorized PURGE should return 403, got %d", code)
+	}
+	if code := purge("PURGE", "/a", auth); code != 200 {
+		t.Fatalf("PURGE should return 200, got %d", code)
+	}
+	if code := purge("PURGE", "/d", auth); code != 404 {
+		t.Fatalf("PURGE of uncached URL should return 404, got %d", code)
+	}
+	if code := purge("BAN", "/", http.Header{"Authorization": []string{"secret"}, "X-Ban-Url": []string{"^/a/"}}); code != 200 {
+		t.Fatalf("BAN should return 200, got %d", code)
+	}
+	if code := purge("BAN", "/", http.Header{"Authorization": []string{"secret"}, "X-Ban-Url": []string{"("}}); code != 400 {
+		t.Fatalf("BAN with invalid pattern should return 400, got %d", code)
+	}
+	if backend != 0 {
+		t.Fatal("Purge requests should not reach the handler")
+	}
+	for url, status := range map[string]string{"/a": "MISS", "/a/b": "MISS", "/c": "HIT"} {
+		if got := getResponse(handler, url).Header().Get("microcache"); got != status {
+			t.Fatalf("%s should be %s, got %s", url, status, got)
+		}
+	}
+}
+
+// Soft purged responses should be revalidated but remain available as stale
+func TestSoftPurge(t *testing.T) {
+	var fail bool
+	cache := New(Config{
+		TTL:              30 * time.Second,
+		StaleIfError:     60 * time.Second,
+		Driver:           NewDriverLRU(10),
+		Exposed:          true,
+		SoftInvalidation: true,
+	})
+	defer cache.Stop()
+	handler := cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(500)
+			return
+		}
+		w.Header().Set("microcache-tags", "t")
+	}))
+	var status = func(url string) string {
+		return getResponse(handler, url).Header().Get("microcache")
+	}
+	var check = func(name string, expected string) {
+		if got := status("/a"); got != expected {
+			t.Fatalf("%s: expected %s, got %s", name, expected, got)
+		}
+	}
+
+	for name, purge := range map[string]func(){
+		"SoftPurge": func() {
+			if n, _ := cache.SoftPurge("/a"); n != 1 {
+				t.Fatal("SoftPurge should mark 1 request")
+			}
+		},
+		"SoftPurgePrefix": func() { cache.SoftPurgePrefix("/") },
+		"SoftPurgeTag":    func() { cache.SoftPurgeTag("t") },
+		"SoftPurgeAll":    func() { cache.SoftPurgeAll() },
+		"SoftInvalidation": func() {
+			getResponseWithMethod(handler, "/a", "POST")
+		},
+	} {
+		fail = false
+		cache.offsetIncr(time.Second)
+		status("/a")
+		check(name, "HIT")
+		purge()
+		fail = true
+		check(name, "STALE")
+	}
+
+	cache.offsetIncr(time.Second)
+	cache.PurgeAll()
+	if w := getResponse(handler, "/a"); w.Code != 500 {
+		t.Fatal("Hard purged response should not be served stale")
+	}
+}
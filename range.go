@@ -0,0 +1,171 @@
+package microcache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errInvalidRange       = errors.New("invalid range")
+	errUnsatisfiableRange = errors.New("unsatisfiable range")
+)
+
+// byteRange is a single byte range of a response body
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses a Range header (RFC 9110 §14.1.2) against a body of the given size.
+// Ranges which lie entirely beyond the end of the body are dropped.
+// errUnsatisfiableRange is returned when no ranges remain.
+func parseRange(s string, size int64) ([]byteRange, error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(s, prefix) {
+		return nil, errInvalidRange
+	}
+	var ranges []byteRange
+	for _, spec := range strings.Split(s[len(prefix):], ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		i := strings.Index(spec, "-")
+		if i < 0 {
+			return nil, errInvalidRange
+		}
+		first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+		var r byteRange
+		if first == "" {
+			// suffix-byte-range-spec: -500
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errInvalidRange
+			}
+			if n == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r.start = size - n
+			r.length = n
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, errInvalidRange
+			}
+			if start >= size {
+				continue
+			}
+			r.start = start
+			if last == "" {
+				r.length = size - start
+			} else {
+				end, err := strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, errInvalidRange
+				}
+				if end >= size {
+					end = size - 1
+				}
+				r.length = end - start + 1
+			}
+		}
+		if r.length > 0 {
+			ranges = append(ranges, r)
+		}
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	return ranges, nil
+}
+
+// ifRangeMatch reports whether a Range request should be honored given its If-Range
+// header. If-Range holds either a strong entity tag or an HTTP date.
+func ifRangeMatch(r *http.Request, header http.Header) bool {
+	ir := r.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		return etagMatch(ir, header.Get("Etag"), true)
+	}
+	since, err := http.ParseTime(ir)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return modified.Truncate(time.Second).Equal(since)
+}
+
+// sendRange sends the requested byte ranges of a cached response body.
+// Multiple ranges are sent as multipart/byteranges.
+// Returns false if the range header should be ignored and the full response sent.
+func (res *Response) sendRange(w http.ResponseWriter, rangeHdr string) bool {
+	size := int64(len(res.body))
+	ranges, err := parseRange(rangeHdr, size)
+	if err == errInvalidRange {
+		return false
+	}
+	if err == errUnsatisfiableRange {
+		res.copyHeaders(w)
+		w.Header().Del("Content-Type")
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return true
+	}
+	var total int64
+	for _, r := range ranges {
+		total += r.length
+	}
+	if total > size {
+		// Overlapping ranges are more expensive than the full response
+		return false
+	}
+
+	res.copyHeaders(w)
+	if len(ranges) == 1 {
+		r := ranges[0]
+		w.Header().Set("Content-Range", r.contentRange(size))
+		w.Header().Set("Content-Length", strconv.FormatInt(r.length, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(res.body[r.start : r.start+r.length])
+		return true
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	contentType := res.header.Get("Content-Type")
+	for _, r := range ranges {
+		h := textproto.MIMEHeader{}
+		if contentType != "" {
+			h.Set("Content-Type", contentType)
+		}
+		h.Set("Content-Range", r.contentRange(size))
+		part, _ := mw.CreatePart(h)
+		part.Write(res.body[r.start : r.start+r.length])
+	}
+	mw.Close()
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write(buf.Bytes())
+	return true
+}
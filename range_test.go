@@ -0,0 +1,123 @@
+package microcache
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseRange parses byte ranges as expected
+func TestParseRange(t *testing.T) {
+	cases := []struct {
+		hdr    string
+		ranges []byteRange
+		err    error
+	}{
+		{"bytes=0-4", []byteRange{{0, 5}}, nil},
+		{"bytes=5-", []byteRange{{5, 5}}, nil},
+		{"bytes=-3", []byteRange{{7, 3}}, nil},
+		{"bytes=-20", []byteRange{{0, 10}}, nil},
+		{"bytes=8-20", []byteRange{{8, 2}}, nil},
+		{"bytes=0-1, 4-5", []byteRange{{0, 2}, {4, 2}}, nil},
+		{"bytes=0-1,20-30", []byteRange{{0, 2}}, nil},
+		{"bytes=10-", nil, errUnsatisfiableRange},
+		{"bytes=-0", nil, errUnsatisfiableRange},
+		{"bytes=5-4", nil, errInvalidRange},
+		{"bytes=a-4", nil, errInvalidRange},
+		{"items=0-4", nil, errInvalidRange},
+	}
+	for i, c := range cases {
+		ranges, err := parseRange(c.hdr, 10)
+		if err != c.err || !reflect.DeepEqual(ranges, c.ranges) {
+			t.Fatalf("Mismatch in case %d\n%#v %v\n%#v %v", i+1, ranges, err, c.ranges, c.err)
+		}
+	}
+}
+
+// Range requests should be served from cached objects
+func TestRangeRequest(t *testing.T) {
+	cache := New(Config{
+		TTL:     30 * time.Second,
+		Driver:  NewDriverLRU(10),
+		Exposed: true,
+	})
+	defer cache.Stop()
+	handler := cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		w.Write([]byte("0123456789"))
+	}))
+	etag := getResponse(handler, "/").Header().Get("Etag")
+	cases := []struct {
+		hdr    map[string]string
+		status int
+		body   string
+		cr     string
+	}{
+		{map[string]string{"Range": "bytes=0-4"}, 206, "01234", "bytes 0-4/10"},
+		{map[string]string{"Range": "bytes=-3"}, 206, "789", "bytes 7-9/10"},
+		{map[string]string{"Range": "bytes=20-"}, 416, "", "bytes */10"},
+		{map[string]string{"Range": "bytes=4-2"}, 200, "0123456789", ""},
+		{map[string]string{"Range": "bytes=0-9,0-9"}, 200, "0123456789", ""},
+		{map[string]string{"Range": "bytes=2-3", "If-Range": etag}, 206, "23", "bytes 2-3/10"},
+		{map[string]string{"Range": "bytes=2-3", "If-Range": `"other"`}, 200, "0123456789", ""},
+		{map[string]string{"Range": "bytes=2-3", "If-Range": "Mon, 02 Jan 2006 15:04:05 GMT"}, 206, "23", "bytes 2-3/10"},
+		{map[string]string{"Range": "bytes=2-3", "If-Range": "Mon, 02 Jan 2006 15:04:06 GMT"}, 200, "0123456789", ""},
+	}
+	for i, c := range cases {
+		h := http.Header{}
+		for k, v := range c.hdr {
+			h.Set(k, v)
+		}
+		w := getResponseWithHeader(handler, "/", h)
+		if w.Code != c.status || w.Body.String() != c.body || w.Header().Get("Content-Range") != c.cr {
+			t.Fatalf("Mismatch in case %d: %d %q %q", i+1, w.Code, w.Body.String(), w.Header().Get("Content-Range"))
+		}
+	}
+
+	// Multiple ranges
+	w := getResponseWithHeader(handler, "/", http.Header{"Range": []string{"bytes=0-1,8-"}})
+	mediaType, params, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if w.Code != 206 || mediaType != "multipart/byteranges" {
+		t.Fatalf("Multiple ranges should be multipart/byteranges, got %d %s", w.Code, mediaType)
+	}
+	mr := multipart.NewReader(w.Body, params["boundary"])
+	var parts []string
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		b, _ := io.ReadAll(part)
+		parts = append(parts, part.Header.Get("Content-Range")+" "+part.Header.Get("Content-Type")+" "+string(b))
+	}
+	if strings.Join(parts, ";") != "bytes 0-1/10 text/plain 01;bytes 8-9/10 text/plain 89" {
+		t.Fatalf("Multipart ranges malformed: %v", parts)
+	}
+}
+
+// Range requests should not reach the backend on a miss
+func TestRangeMiss(t *testing.T) {
+	cache := New(Config{
+		TTL:     30 * time.Second,
+		Driver:  NewDriverLRU(10),
+		Exposed: true,
+	})
+	defer cache.Stop()
+	handler := cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("hello"))
+	}))
+	w := getResponseWithHeader(handler, "/", http.Header{"Range": []string{"bytes=0-1"}})
+	if w.Code != 206 || w.Body.String() != "he" || w.Header().Get("microcache") != "MISS" {
+		t.Fatalf("Range miss should be answered with 206, got %d %q", w.Code, w.Body.String())
+	}
+	w = getResponse(handler, "/")
+	if w.Code != 200 || w.Body.String() != "hello" || w.Header().Get("microcache") != "HIT" {
+		t.Fatalf("Range miss should store the full response, got %d %q", w.Code, w.Body.String())
+	}
+}
@@ -8,9 +8,19 @@ import (
 	"time"
 )
 
+// getRequestMethod returns the method under which a request is cached.
+// HEAD requests are answered from GET entries and unsafe requests address
+// GET entries for invalidation. OPTIONS requests have their own entries.
+func getRequestMethod(r *http.Request) string {
+	if r.Method == "OPTIONS" {
+		return "OPTIONS"
+	}
+	return "GET"
+}
+
 func getRequestHash(m *microcache, r *http.Request) string {
 	h := sha1.New()
-	h.Write([]byte(r.URL.Path))
+	h.Write([]byte(getRequestMethod(r) + " " + r.URL.Path))
 	for _, header := range m.Vary {
 		h.Write([]byte("&" + header + ":" + r.Header.Get(header)))
 	}
@@ -44,6 +54,15 @@ type RequestOpts struct {
 	vary                 []string
 	varyQuery            []string
 	nocache              bool
+	revalidate           bool
+	negativeTTL          time.Duration
+	path                 string
+	purged               time.Time
+	softPurged           time.Time
+
+	// negative options were built from a negative response and are replaced
+	// by options built from the first successful response
+	negative bool
 
 	hash string
 }
@@ -78,6 +97,14 @@ func buildRequestOpts(m *microcache, res Response, r *http.Request) RequestOpts
 		staleWhileRevalidate: m.StaleWhileRevalidate,
 		collapsedForwarding:  m.CollapsedForwarding,
 		vary:                 m.Vary,
+		revalidate:           m.Revalidate,
+		negativeTTL:          m.NegativeTTL,
+		path:                 r.URL.Path,
+	}
+
+	// w.Header().Set("Cache-Control", "max-age=10, stale-if-error=20")
+	if m.ResponseCacheControl && !hasMicrocacheHeader(headers) {
+		applyResponseCacheControl(&req, headers)
 	}
 
 	// w.Header().Set("microcache-cache", "1")
@@ -96,6 +123,12 @@ func buildRequestOpts(m *microcache, res Response, r *http.Request) RequestOpts
 		req.ttl = time.Duration(ttlHdr) * time.Second
 	}
 
+	// w.Header().Set("microcache-negative-ttl", "5") // 5 seconds
+	negativeTTLHdr, _ := strconv.Atoi(headers.Get("microcache-negative-ttl"))
+	if negativeTTLHdr > 0 {
+		req.negativeTTL = time.Duration(negativeTTLHdr) * time.Second
+	}
+
 	// w.Header().Set("microcache-stale-if-error", "20") // 20 seconds
 	staleIfErrorHdr, _ := strconv.Atoi(headers.Get("microcache-stale-if-error"))
 	if staleIfErrorHdr > 0 {
@@ -128,6 +161,16 @@ func buildRequestOpts(m *microcache, res Response, r *http.Request) RequestOpts
 		req.staleRecache = false
 	}
 
+	// w.Header().Set("microcache-revalidate", "1")
+	if headers.Get("microcache-revalidate") != "" {
+		req.revalidate = true
+	}
+
+	// w.Header().Set("microcache-no-revalidate", "1")
+	if headers.Get("microcache-no-revalidate") != "" {
+		req.revalidate = false
+	}
+
 	// w.Header().Add("microcache-vary-query", "q, page, limit")
 	if varyQueries, ok := headers["Microcache-Vary-Query"]; ok {
 		for _, hdr := range varyQueries {
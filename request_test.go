@@ -22,6 +22,7 @@ func TestBuildRequestOpts(t *testing.T) {
 			res.Header().Set(c.hdr, c.val)
 			reqOpts := buildRequestOpts(m, res, r)
 			reqOpts.found = false
+			reqOpts.path = ""
 			if !reflect.DeepEqual(reqOpts, c.exp) {
 				t.Fatalf("Mismatch in case %d\n%#v\n%#v", i+1, reqOpts, c.exp)
 			}
@@ -36,6 +37,8 @@ func TestBuildRequestOpts(t *testing.T) {
 		{"microcache-collapsed-forwarding", "1", RequestOpts{collapsedForwarding: true}},
 		{"microcache-stale-recache", "1", RequestOpts{staleRecache: true}},
 		{"Microcache-Vary-Query", "a", RequestOpts{varyQuery: []string{"a"}}},
+		{"microcache-revalidate", "1", RequestOpts{revalidate: true}},
+		{"microcache-negative-ttl", "10", RequestOpts{negativeTTL: time.Duration(10 * time.Second)}},
 	})
 	runCases(New(Config{Nocache: true}), []tc{
 		{"microcache-cache", "1", RequestOpts{nocache: false}},
@@ -46,10 +49,34 @@ func TestBuildRequestOpts(t *testing.T) {
 	runCases(New(Config{StaleRecache: true}), []tc{
 		{"microcache-no-stale-recache", "1", RequestOpts{staleRecache: false}},
 	})
+	runCases(New(Config{Revalidate: true}), []tc{
+		{"microcache-no-revalidate", "1", RequestOpts{revalidate: false}},
+	})
+	runCases(New(Config{ResponseCacheControl: true, Nocache: true, TTL: 5 * time.Second}), []tc{
+		{"Cache-Control", "max-age=10", RequestOpts{ttl: 10 * time.Second}},
+		{"Cache-Control", "max-age=10, s-maxage=20", RequestOpts{ttl: 20 * time.Second}},
+		{"Cache-Control", "public, max-age=0", RequestOpts{nocache: true, ttl: 5 * time.Second}},
+		{"Cache-Control", "max-age=10, no-store", RequestOpts{nocache: true, ttl: 5 * time.Second}},
+		{"Cache-Control", "no-cache", RequestOpts{nocache: true, ttl: 5 * time.Second}},
+		{"Cache-Control", "private, max-age=10", RequestOpts{nocache: true, ttl: 5 * time.Second}},
+		{"Cache-Control", "max-age=10, stale-while-revalidate=20, stale-if-error=30", RequestOpts{
+			ttl: 10 * time.Second, staleWhileRevalidate: 20 * time.Second, staleIfError: 30 * time.Second}},
+		{"Expires", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), RequestOpts{ttl: 59 * time.Second}},
+		{"Expires", "0", RequestOpts{nocache: true, ttl: 5 * time.Second}},
+		{"microcache-cache", "1", RequestOpts{ttl: 5 * time.Second}},
+	})
 	runCases(New(Config{Vary: []string{"a"}}), []tc{
 		{"Microcache-Vary", "b", RequestOpts{vary: []string{"a", "b"}}},
 	})
 	runCases(New(Config{Vary: []string{"a"}}), []tc{
 		{"Vary", "b", RequestOpts{vary: []string{"a", "b"}}},
 	})
+
+	// Tags do not disable Cache-Control
+	res := Response{header: http.Header{}}
+	res.Header().Set("Cache-Control", "private")
+	res.Header().Set("microcache-tags", "user-7")
+	if req := buildRequestOpts(New(Config{ResponseCacheControl: true}), res, r); !req.nocache {
+		t.Fatal("microcache-tags should not disable ResponseCacheControl")
+	}
 }
@@ -1,7 +1,10 @@
 package microcache
 
 import (
+	"bufio"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -18,23 +21,121 @@ type Response struct {
 	body          []byte
 
 	hash string
+
+	// Time taken by the backend to render the response
+	delta time.Duration
+
+	// Period after expiration during which the response may be served stale
+	stale time.Duration
+
+	// Hash of the request the response was stored for, used to remove the
+	// responses of purged requests
+	reqHash string
+
+	// Used while capturing backend responses
+	w           http.ResponseWriter
+	maxBodySize int
+	tee         bool
+	sent        bool
+	uncacheable bool
+	writeErr    error
 }
 
 func (res *Response) Write(b []byte) (int, error) {
+	if res.tee && !res.sent {
+		res.sendHeader()
+	}
+	if res.uncacheable {
+		return res.writeClient(b)
+	}
+	if res.maxBodySize > 0 && len(res.body)+len(b) > res.maxBodySize {
+		return res.stream(b)
+	}
 	res.body = append(res.body, b...)
+	if res.sent {
+		return res.writeClient(b)
+	}
 	return len(b), nil
 }
 
+// stream stops capturing a response body which exceeds maxBodySize and sends
+// the response to the client instead. Background requests have no client
+// so the rest of their body is discarded.
+func (res *Response) stream(b []byte) (int, error) {
+	res.uncacheable = true
+	body := res.body
+	res.body = nil
+	if res.w != nil && !res.sent {
+		res.sendHeader()
+		if _, err := res.writeClient(body); err != nil {
+			return 0, err
+		}
+	}
+	return res.writeClient(b)
+}
+
+// sendHeader sends the response headers to the client ahead of the body
+func (res *Response) sendHeader() {
+	res.sent = true
+	res.copyHeaders(res.w)
+	if res.headerWritten {
+		res.w.WriteHeader(res.status)
+	}
+}
+
+// writeClient writes part of the response body to the client if it has been
+// sent the response headers
+func (res *Response) writeClient(b []byte) (int, error) {
+	if !res.sent {
+		return len(b), nil
+	}
+	n, err := res.w.Write(b)
+	if err != nil && res.writeErr == nil {
+		res.writeErr = err
+	}
+	return n, err
+}
+
+// Flush flushes buffered data to the client while a response is being sent
+func (res *Response) Flush() {
+	if res.sent {
+		if f, ok := res.w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+}
+
 func (res *Response) Header() http.Header {
 	return res.header
 }
 
 func (res *Response) WriteHeader(code int) {
+	if res.sent {
+		return
+	}
 	res.status = code
 	res.headerWritten = true
+	if res.tee {
+		// Server errors are buffered so that stale responses can be served instead
+		if code >= 500 {
+			res.tee = false
+		} else {
+			res.sendHeader()
+		}
+	}
 }
 
 func (res *Response) sendResponse(w http.ResponseWriter) {
+	res.copyHeaders(w)
+	if res.headerWritten {
+		w.WriteHeader(res.status)
+	}
+	w.Write(res.body)
+	return
+}
+
+// copyHeaders copies the response headers to w, omitting microcache headers
+func (res *Response) copyHeaders(w http.ResponseWriter) {
 	for header, values := range res.header {
 		// Do not forward microcache headers to client
 		if strings.HasPrefix(header, "Microcache-") {
@@ -44,11 +145,38 @@ func (res *Response) sendResponse(w http.ResponseWriter) {
 			w.Header().Add(header, val)
 		}
 	}
-	if res.headerWritten {
-		w.WriteHeader(res.status)
+}
+
+// sendCachedResponse sends a cached response to the client, answering
+// conditional requests with 304 Not Modified when the client's validators match
+// and byte range requests with 206 Partial Content. HEAD requests are answered
+// with headers only.
+func (res *Response) sendCachedResponse(w http.ResponseWriter, r *http.Request) {
+	if res.status >= 200 && res.status < 300 && notModified(r, res.header) {
+		for _, header := range notModifiedHeaders {
+			if values, ok := res.header[header]; ok {
+				w.Header()[header] = append([]string(nil), values...)
+			}
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return
 	}
-	w.Write(res.body)
-	return
+	if res.status == http.StatusOK {
+		w.Header().Set("Accept-Ranges", "bytes")
+		if rangeHdr := r.Header.Get("Range"); rangeHdr != "" && r.Method == "GET" &&
+			ifRangeMatch(r, res.header) && res.sendRange(w, rangeHdr) {
+			return
+		}
+	}
+	if r.Method == "HEAD" {
+		res.copyHeaders(w)
+		w.Header().Set("Content-Length", strconv.Itoa(len(res.body)))
+		if res.headerWritten {
+			w.WriteHeader(res.status)
+		}
+		return
+	}
+	res.sendResponse(w)
 }
 
 func (res *Response) clone() Response {
@@ -59,6 +187,9 @@ func (res *Response) clone() Response {
 		status:  res.status,
 		header:  res.header,
 		body:    res.body,
+		delta:   res.delta,
+		stale:   res.stale,
+		reqHash: res.reqHash,
 	}
 }
 
@@ -71,3 +202,23 @@ func (w *passthroughWriter) WriteHeader(code int) {
 	w.status = code
 	w.ResponseWriter.WriteHeader(code)
 }
+
+// Flush allows streaming handlers to flush responses to unsafe requests
+func (w *passthroughWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack allows handlers of unsafe requests to take over the connection
+func (w *passthroughWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+// Unwrap returns the underlying http.ResponseWriter
+func (w *passthroughWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,225 @@
+package microcache
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// tagIndex maps surrogate keys (cache tags) to the hashes of the response
+// objects labelled with them.
+//
+//	w.Header().Set("microcache-tags", "product-42, category-7")
+//
+// Entries are removed when objects are replaced, removed or evicted from
+// drivers implementing EvictionNotifier. For other drivers the index is pruned
+// of evicted objects whenever it doubles in size.
+type tagIndex struct {
+	mutex sync.Mutex
+	tags  map[string]map[string]bool
+	objs  map[string]tagEntry
+	seq   uint64
+
+	// pruneAt is the size at which the index is next pruned
+	pruneAt int
+	pruning bool
+}
+
+type tagEntry struct {
+	tags []string
+	seq  uint64
+}
+
+// Minimum size of the index before it is pruned
+const tagPruneMin = 1000
+
+func newTagIndex() *tagIndex {
+	return &tagIndex{
+		tags:    map[string]map[string]bool{},
+		objs:    map[string]tagEntry{},
+		pruneAt: tagPruneMin,
+	}
+}
+
+// set replaces the tags of a response object
+func (t *tagIndex) set(objHash string, tags []string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.remove(objHash)
+	if len(tags) == 0 {
+		return
+	}
+	t.seq++
+	t.objs[objHash] = tagEntry{tags, t.seq}
+	for _, tag := range tags {
+		hashes, ok := t.tags[tag]
+		if !ok {
+			hashes = map[string]bool{}
+			t.tags[tag] = hashes
+		}
+		hashes[objHash] = true
+	}
+}
+
+// evict removes a response object from the index
+func (t *tagIndex) evict(objHash string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.remove(objHash)
+}
+
+// take removes a tag from the index and returns the hashes of its response objects
+func (t *tagIndex) take(tag string) []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	var objHashes []string
+	for objHash := range t.tags[tag] {
+		objHashes = append(objHashes, objHash)
+		t.remove(objHash)
+	}
+	return objHashes
+}
+
+// get returns the hashes of the response objects labelled with a tag
+func (t *tagIndex) get(tag string) []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	var objHashes []string
+	for objHash := range t.tags[tag] {
+		objHashes = append(objHashes, objHash)
+	}
+	return objHashes
+}
+
+func (t *tagIndex) remove(objHash string) {
+	for _, tag := range t.objs[objHash].tags {
+		delete(t.tags[tag], objHash)
+		if len(t.tags[tag]) == 0 {
+			delete(t.tags, tag)
+		}
+	}
+	delete(t.objs, objHash)
+}
+
+// startPrune reports whether the index is due to be pruned and marks the
+// prune as started
+func (t *tagIndex) startPrune() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.pruning || len(t.objs) < t.pruneAt {
+		return false
+	}
+	t.pruning = true
+	return true
+}
+
+// entries returns the indexed response object hashes with their sequence numbers
+func (t *tagIndex) entries() map[string]uint64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	entries := make(map[string]uint64, len(t.objs))
+	for objHash, e := range t.objs {
+		entries[objHash] = e.seq
+	}
+	return entries
+}
+
+// evictEntry removes a response object from the index unless it was
+// labelled again since seq
+func (t *tagIndex) evictEntry(objHash string, seq uint64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if e, ok := t.objs[objHash]; ok && e.seq == seq {
+		t.remove(objHash)
+	}
+}
+
+// endPrune schedules the next prune for when the index has grown to twice the
+// entries kept by the prune. Entries added while pruning count towards it.
+func (t *tagIndex) endPrune(kept int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.pruning = false
+	t.pruneAt = 2 * kept
+	if t.pruneAt < tagPruneMin {
+		t.pruneAt = tagPruneMin
+	}
+}
+
+// pruneTagIndex drops the tag index entries of response objects which the driver
+// no longer holds
+func (m *microcache) pruneTagIndex() {
+	entries := m.tags.entries()
+	kept := len(entries)
+	for objHash, seq := range entries {
+		if !m.hasObject(objHash) {
+			m.tags.evictEntry(objHash, seq)
+			kept--
+		}
+	}
+	m.tags.endPrune(kept)
+}
+
+// objectContainer is implemented by drivers able to report whether they hold
+// a response object without fetching it or affecting its eviction
+type objectContainer interface {
+	containsObject(hash string) bool
+}
+
+// hasObject reports whether the driver holds a response object
+func (m *microcache) hasObject(objHash string) bool {
+	if d, ok := m.Driver.(objectContainer); ok {
+		return d.containsObject(objHash)
+	}
+	obj, _ := m.getObject(context.Background(), objHash)
+	return obj.found
+}
+
+// getResponseTags parses the microcache-tags header of a response
+func getResponseTags(res Response) (tags []string) {
+	for _, hdr := range res.header["Microcache-Tags"] {
+		for _, tag := range strings.Split(hdr, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// PurgeTag removes all cached responses labelled with tag by the microcache-tags
+// response header. It returns the number of responses removed.
+func (m *microcache) PurgeTag(tag string) int {
+	var n int
+	for _, objHash := range m.tags.take(tag) {
+		// The index may hold evicted objects of drivers which do not report evictions
+		if m.pruneTags && !m.hasObject(objHash) {
+			continue
+		}
+		if m.remove(objHash) == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// SoftPurgeTag marks all cached responses labelled with tag stale.
+// It returns the number of responses marked.
+func (m *microcache) SoftPurgeTag(tag string) int {
+	var n int
+	now := m.now()
+	for _, objHash := range m.tags.get(tag) {
+		obj, _ := m.getObject(context.Background(), objHash)
+		if !obj.found {
+			continue
+		}
+		if obj.expires.After(now) {
+			obj.expires = now
+			if m.setObject(objHash, obj) != nil {
+				continue
+			}
+		}
+		n++
+	}
+	return n
+}
@@ -0,0 +1,119 @@
+package microcache
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// PurgeTag should remove all responses labelled with a tag
+func TestPurgeTag(t *testing.T) {
+	var testDriver = func(name string, d Driver) {
+		cache := New(Config{
+			TTL:     30 * time.Second,
+			Driver:  d,
+			Exposed: true,
+		})
+		defer cache.Stop()
+		handler := cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/product/42":
+				w.Header().Set("microcache-tags", "product-42, category-7")
+			case "/category/7":
+				w.Header().Add("microcache-tags", "category-7")
+				w.Header().Add("microcache-tags", "product-42,product-43")
+			case "/product/43":
+				w.Header().Set("microcache-tags", "product-43,category-7")
+			}
+		}))
+		urls := []string{"/product/42", "/product/43", "/category/7", "/other"}
+		batchGet(handler, urls)
+		if r, ok := d.(DriverRistretto); ok {
+			r.Cache.Wait()
+		}
+		if w := getResponse(handler, "/product/42"); w.Header().Get("microcache-tags") != "" {
+			t.Fatalf("%s tags header should not be forwarded to client", name)
+		}
+		if n := cache.PurgeTag("product-42"); n != 2 {
+			t.Fatalf("%s PurgeTag should remove 2 responses, got %d", name, n)
+		}
+		if r, ok := d.(DriverRistretto); ok {
+			r.Cache.Wait()
+		}
+		for i, hit := range []bool{false, true, false, true} {
+			if w := getResponse(handler, urls[i]); hit != (w.Header().Get("microcache") == "HIT") {
+				t.Fatalf("%s Hit should have been %v for %s", name, hit, urls[i])
+			}
+		}
+		if n := cache.PurgeTag("product-42"); n != 2 {
+			t.Fatalf("%s PurgeTag should remove recached responses, got %d", name, n)
+		}
+		if n := cache.PurgeTag("category-7"); n != 1 {
+			t.Fatalf("%s PurgeTag should remove remaining responses, got %d", name, n)
+		}
+	}
+	testDriver("ARC", NewDriverARC(10))
+	testDriver("LRU", NewDriverLRU(10))
+	testDriver("Ristretto", NewDriverRistretto(100, 1e6))
+}
+
+// Tag index should be cleaned up on eviction
+func TestTagEviction(t *testing.T) {
+	cache := New(Config{
+		TTL:    30 * time.Second,
+		Driver: NewDriverLRU(2),
+	})
+	defer cache.Stop()
+	handler := cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("microcache-tags", "all")
+	}))
+	batchGet(handler, []string{"/1", "/2", "/3", "/4"})
+	if len(cache.tags.objs) != 2 || len(cache.tags.tags["all"]) != 2 {
+		t.Fatalf("Tag index should only contain cached responses, got %d", len(cache.tags.objs))
+	}
+	cache.PurgeTag("all")
+	if len(cache.tags.objs) != 0 || len(cache.tags.tags) != 0 {
+		t.Fatal("Tag index should be empty after purge")
+	}
+}
+
+// Tag index should be pruned for drivers which do not report evictions
+func TestTagPrune(t *testing.T) {
+	cache := New(Config{
+		TTL:    30 * time.Second,
+		Driver: NewDriverARC(2),
+	})
+	defer cache.Stop()
+	handler := cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("microcache-tags", "all")
+	}))
+	var urls []string
+	for i := 0; i < 100; i++ {
+		urls = append(urls, "/"+strconv.Itoa(i))
+	}
+	batchGet(handler, urls)
+	if n := cache.PurgeTag("all"); n != 2 {
+		t.Fatalf("PurgeTag should only count responses held by the driver, got %d", n)
+	}
+
+	cache.tags.mutex.Lock()
+	cache.tags.pruneAt = 10
+	cache.tags.mutex.Unlock()
+	batchGet(handler, urls)
+	var pruneAt int
+	for pruning := true; pruning; time.Sleep(time.Millisecond) {
+		cache.tags.mutex.Lock()
+		pruneAt, pruning = cache.tags.pruneAt, cache.tags.pruning
+		cache.tags.mutex.Unlock()
+	}
+	if pruneAt != tagPruneMin {
+		t.Fatal("Tag index should be pruned in the background")
+	}
+
+	// Entries added during the background prune are pruned by the next one
+	cache.pruneTagIndex()
+	if size := len(cache.tags.objs); size > 2 {
+		t.Fatalf("Tag index should be pruned of evicted responses, got %d", size)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/erikdubbelboer/microcache"
+)
+
+type headerSets []http.Header
+
+func (h *headerSets) String() string {
+	return fmt.Sprint(*h)
+}
+
+// Set parses a header set in the form "Name: value|Name2: value2"
+func (h *headerSets) Set(s string) error {
+	header := http.Header{}
+	for _, line := range strings.Split(s, "|") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid header %q", line)
+		}
+		header.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	*h = append(*h, header)
+	return nil
+}
+
+// This script warms the cache of a running server by requesting every URL in
+// a file (such as the one generated by random_url_generator) once per header set
+//
+//	cache_warmer -f /tmp/urls.txt -c 20 -H "Accept-Language: en" -H "Accept-Language: fr"
+func main() {
+	var headers headerSets
+	var filepath *string = flag.String("f", "/tmp/urls.txt", "File containing one URL per line")
+	var concurrency *int = flag.Int("c", 10, "Number of concurrent requests")
+	flag.Var(&headers, "H", "Header set to warm, repeatable (\"Name: value|Name2: value2\")")
+	flag.Parse()
+
+	f, err := os.Open(*filepath)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	var urls []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if url := strings.TrimSpace(scanner.Text()); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	f.Close()
+
+	reqs, err := microcache.WarmRequests(urls, headers)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+
+	// Requests are proxied to the server whose cache is being warmed
+	proxy := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer res.Body.Close()
+		for name, values := range res.Header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(res.StatusCode)
+		io.Copy(w, res.Body)
+	})
+
+	var failed int
+	for _, res := range microcache.Warm(context.Background(), proxy, reqs, *concurrency) {
+		ok := res.Err == nil && res.Status < 400
+		if !ok {
+			failed++
+		}
+		fmt.Printf("%t %d %s %s %v\n", ok, res.Status, res.Cache, res.Request.URL, res.Request.Header)
+	}
+	fmt.Printf("%d/%d succeeded\n", len(reqs)-failed, len(reqs))
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,118 @@
+package microcache
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// WarmResult is the outcome of a single cache warming request
+type WarmResult struct {
+	Request *http.Request
+
+	// Status is the response status code
+	Status int
+
+	// Cache is the value of the microcache response header (HIT, MISS, STALE)
+	// when Config.Exposed is set
+	Cache string
+
+	// Err is set if the request was not sent because ctx was done
+	Err error
+}
+
+// Warm sends requests through a handler wrapped by Middleware so that their
+// responses are stored in the cache. At most concurrency requests are in flight
+// at once. Response bodies are discarded.
+//
+//	reqs, _ := microcache.WarmRequests(urls, []http.Header{
+//		{"Accept-Language": []string{"en"}},
+//		{"Accept-Language": []string{"fr"}},
+//	})
+//	results := microcache.Warm(ctx, cache.Middleware(handler), reqs, 10)
+//
+// Results are returned in the order of reqs.
+func Warm(ctx context.Context, h http.Handler, reqs []*http.Request, concurrency int) []WarmResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	results := make([]WarmResult, len(reqs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				w := &warmWriter{header: http.Header{}}
+				h.ServeHTTP(w, reqs[i].WithContext(ctx))
+				if w.status == 0 {
+					w.status = http.StatusOK
+				}
+				results[i] = WarmResult{
+					Request: reqs[i],
+					Status:  w.status,
+					Cache:   w.header.Get("microcache"),
+				}
+			}
+		}()
+	}
+	for i := range reqs {
+		if ctx.Err() != nil {
+			results[i] = WarmResult{Request: reqs[i], Err: ctx.Err()}
+			continue
+		}
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			results[i] = WarmResult{Request: reqs[i], Err: ctx.Err()}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// WarmRequests builds a GET request for every combination of URL and header set.
+// Header sets should cover the values of the Config.Vary headers to be warmed.
+// A single request without headers is built per URL if headerSets is empty.
+func WarmRequests(urls []string, headerSets []http.Header) ([]*http.Request, error) {
+	if len(headerSets) == 0 {
+		headerSets = []http.Header{{}}
+	}
+	var reqs []*http.Request
+	for _, url := range urls {
+		for _, header := range headerSets {
+			r, err := http.NewRequest("GET", url, nil)
+			if err != nil {
+				return nil, err
+			}
+			r.Header = header.Clone()
+			reqs = append(reqs, r)
+		}
+	}
+	return reqs, nil
+}
+
+// warmWriter records the status and headers of a response and discards its body
+type warmWriter struct {
+	header http.Header
+	status int
+}
+
+func (w *warmWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *warmWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return len(b), nil
+}
+
+func (w *warmWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
@@ -0,0 +1,57 @@
+package microcache
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Warm should store responses for every URL and header set
+func TestWarm(t *testing.T) {
+	cache := New(Config{
+		TTL:     30 * time.Second,
+		Vary:    []string{"Accept-Language"},
+		Driver:  NewDriverLRU(10),
+		Exposed: true,
+	})
+	defer cache.Stop()
+	handler := cache.Middleware(http.HandlerFunc(noopSuccessHandler))
+	headerSets := []http.Header{
+		{"Accept-Language": []string{"en"}},
+		{"Accept-Language": []string{"fr"}},
+	}
+	reqs, err := WarmRequests([]string{"http://localhost/a", "http://localhost/b"}, headerSets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 4 {
+		t.Fatalf("WarmRequests should build 4 requests, got %d", len(reqs))
+	}
+	results := Warm(context.Background(), handler, reqs, 2)
+	for i, res := range results {
+		if res.Request != reqs[i] || res.Status != 200 || res.Cache != "MISS" || res.Err != nil {
+			t.Fatalf("Warm request %d failed: %+v", i, res)
+		}
+	}
+	for _, url := range []string{"/a", "/b"} {
+		for _, h := range headerSets {
+			if getResponseWithHeader(handler, url, h).Header().Get("microcache") != "HIT" {
+				t.Fatalf("%s %v should be warm", url, h)
+			}
+		}
+	}
+
+	// Cancelled context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, res := range Warm(ctx, handler, reqs, 2) {
+		if res.Err != context.Canceled {
+			t.Fatal("Warm should not send requests after ctx is done")
+		}
+	}
+
+	if _, err := WarmRequests([]string{"%zz"}, nil); err == nil {
+		t.Fatal("WarmRequests should fail on invalid URL")
+	}
+}